@@ -2,18 +2,44 @@ package main
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/BurntSushi/toml"
+	"github.com/freman/scantp/metrics"
+	"github.com/sirupsen/logrus"
+	"goftp.io/server"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type configuration struct {
-	Host      string                    `toml:"host"`
-	Port      int                       `toml:"port"`
-	Username  string                    `toml:"username"`
-	Password  string                    `toml:"password"`
-	PlainText bool                      `toml:"plaintext"`
-	Paths     map[string]toml.Primitive `toml:"path"`
+	Host       string                    `toml:"host"`
+	Port       int                       `toml:"port"`
+	Username   string                    `toml:"username"`
+	Password   string                    `toml:"password"`
+	PlainText  bool                      `toml:"plaintext"`
+	TLSCert    string                    `toml:"tls_cert"`
+	TLSKey     string                    `toml:"tls_key"`
+	TLSMode    string                    `toml:"tls_mode"`
+	TLSPort    int                       `toml:"tls_port"`
+	RequireTLS bool                      `toml:"require_tls"`
+	Metrics    string                    `toml:"metrics"`
+	LogLevel   string                    `toml:"log_level"`
+	LogFormat  string                    `toml:"log_format"`
+	Users      map[string]user           `toml:"user"`
+	Paths      map[string]toml.Primitive `toml:"path"`
+}
+
+type user struct {
+	Password  string   `toml:"password"`
+	PlainText bool     `toml:"plaintext"`
+	Paths     []string `toml:"paths"`
+}
+
+func (u user) checkPasswd(password string) bool {
+	if u.PlainText {
+		return u.Password == password
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
 }
 
 var config = configuration{
@@ -23,8 +49,19 @@ var config = configuration{
 	Password: "$2y$12$3TwvitKJL3L4/4XVMFFgAOYVCsnj6jZ/cxRBF2/ynbrQPYOEUzqEm", // scanme
 }
 
-func (c configuration) CheckPasswd(username, password string) (bool, error) {
-	if username == c.Username {
+func (c configuration) CheckPasswd(username, password string) (ok bool, err error) {
+	known := ""
+	defer func() {
+		metrics.Login(known, err)
+		if err != nil {
+			logrus.WithField("user", username).Warn("login failed")
+		} else {
+			logrus.WithField("user", username).Info("logged in")
+		}
+	}()
+
+	if c.Username != "" && username == c.Username {
+		known = username
 		if c.PlainText {
 			if c.Password == password {
 				return true, nil
@@ -32,6 +69,112 @@ func (c configuration) CheckPasswd(username, password string) (bool, error) {
 		} else if err := bcrypt.CompareHashAndPassword([]byte(c.Password), []byte(password)); err == nil {
 			return true, nil
 		}
+	} else if u, isa := c.Users[username]; isa {
+		known = username
+		if u.checkPasswd(password) {
+			return true, nil
+		}
 	}
 	return false, errors.New("invalid credentials")
 }
+
+// Allowed implements driver.Authorizer, the top level user may use every
+// path while each [user.name] may only use the paths they've been given
+func (c configuration) Allowed(username, pathName string) bool {
+	if c.Username != "" && username == c.Username {
+		return true
+	}
+
+	u, isa := c.Users[username]
+	if !isa {
+		return false
+	}
+
+	for _, p := range u.Paths {
+		if p == "*" || p == pathName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate checks the users make sense once the configuration has been loaded
+func (c *configuration) validate(md toml.MetaData) error {
+	// Don't leave the built in default user lying around if users have been
+	// configured but the top level one hasn't
+	if len(c.Users) > 0 && !md.IsDefined("username") {
+		c.Username = ""
+	}
+
+	for name, u := range c.Users {
+		if name == c.Username {
+			return fmt.Errorf("user %q is already configured as the top level user", name)
+		}
+
+		if u.Password == "" {
+			return fmt.Errorf("user %q requires a password", name)
+		}
+
+		for _, p := range u.Paths {
+			if _, isa := c.Paths[p]; !isa && p != "*" {
+				return fmt.Errorf("user %q is allowed path %q which isn't configured", name, p)
+			}
+		}
+	}
+
+	return nil
+}
+
+// listeners works out which ftp servers need to be started, the server
+// library won't let anyone log in without AUTH TLS once tls is turned on so
+// plain ftp has to be served from a listener of its own
+func (c configuration) listeners(factory server.DriverFactory) ([]*server.ServerOpts, error) {
+	plain := &server.ServerOpts{
+		Factory:  factory,
+		Hostname: c.Host,
+		Port:     c.Port,
+		Auth:     c,
+		Logger:   ftpLogger{},
+	}
+
+	if c.TLSCert == "" && c.TLSKey == "" {
+		if c.RequireTLS {
+			return nil, errors.New("require_tls needs tls_cert and tls_key")
+		}
+		return []*server.ServerOpts{plain}, nil
+	}
+
+	if c.TLSCert == "" || c.TLSKey == "" {
+		return nil, errors.New("both tls_cert and tls_key are required for tls")
+	}
+
+	secure := *plain
+	secure.TLS = true
+	secure.CertFile = c.TLSCert
+	secure.KeyFile = c.TLSKey
+
+	// Clients speaking TLS from the start never send AUTH TLS, which the
+	// server library won't let anyone log in without, so implicit FTPS
+	// can't be offered
+	switch c.TLSMode {
+	case "", "explicit":
+		secure.ExplicitFTPS = true
+	default:
+		return nil, fmt.Errorf("unsupported tls_mode %q, only explicit FTPS (AUTH TLS) is supported", c.TLSMode)
+	}
+
+	if c.TLSPort != 0 {
+		secure.Port = c.TLSPort
+	}
+
+	if c.RequireTLS {
+		return []*server.ServerOpts{&secure}, nil
+	}
+
+	if secure.Port == plain.Port {
+		return nil, errors.New("tls_port must be set to a different port to keep serving plain ftp, or set require_tls to only serve ftps")
+	}
+
+	return []*server.ServerOpts{plain, &secure}, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loaded decodes and validates a configuration the way main does
+func loaded(t *testing.T, text string) (configuration, error) {
+	t.Helper()
+
+	c := configuration{Username: "scanner", Password: "scanme", PlainText: true}
+	md, err := toml.Decode(text, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, c.validate(md)
+}
+
+func TestCheckPasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := configuration{
+		Username: "admin",
+		Password: string(hash),
+		Users: map[string]user{
+			"alice": {Password: "wonderland", PlainText: true},
+			"bob":   {Password: string(hash)},
+		},
+	}
+
+	tests := []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"admin", "hashed", true},
+		{"admin", "wonderland", false},
+		{"alice", "wonderland", true},
+		{"alice", "hashed", false},
+		{"bob", "hashed", true},
+		{"bob", "", false},
+		{"mallory", "hashed", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		ok, err := c.CheckPasswd(test.username, test.password)
+		if ok != test.ok || (err == nil) != test.ok {
+			t.Errorf("%s/%s: got %v, %v", test.username, test.password, ok, err)
+		}
+	}
+
+	// A plain text top level password
+	c.Password, c.PlainText = "scanme", true
+	if ok, _ := c.CheckPasswd("admin", "scanme"); !ok {
+		t.Error("plain text top level password was refused")
+	}
+	if ok, _ := c.CheckPasswd("admin", "hashed"); ok {
+		t.Error("wrong top level password was accepted")
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	c := configuration{
+		Username: "admin",
+		Users: map[string]user{
+			"alice": {Paths: []string{"docs", "archive"}},
+			"bob":   {Paths: []string{"*"}},
+			"carol": {},
+		},
+	}
+
+	tests := []struct {
+		username, path string
+		allowed        bool
+	}{
+		{"admin", "docs", true},
+		{"admin", "anything", true},
+		{"alice", "docs", true},
+		{"alice", "archive", true},
+		{"alice", "invoices", false},
+		{"bob", "invoices", true},
+		{"carol", "docs", false},
+		{"mallory", "docs", false},
+		{"", "docs", false},
+	}
+
+	for _, test := range tests {
+		if got := c.Allowed(test.username, test.path); got != test.allowed {
+			t.Errorf("%s on %s: got %v, expected %v", test.username, test.path, got, test.allowed)
+		}
+	}
+
+	// Without a top level user nobody gets everything by having no name
+	c.Username = ""
+	if c.Allowed("", "docs") {
+		t.Error("the empty user was allowed in")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const paths = `
+[path.docs]
+type = "fs"
+`
+
+	tests := map[string]struct {
+		config string
+		err    string
+	}{
+		"same as top level": {"username = \"scanner\"\n[user.scanner]\npassword = \"x\"\n" + paths, "already configured as the top level user"},
+		"no password":       {"username = \"admin\"\n[user.alice]\npaths = [\"docs\"]\n" + paths, "requires a password"},
+		"unknown path":      {"[user.alice]\npassword = \"x\"\npaths = [\"invoices\"]\n" + paths, "which isn't configured"},
+		"wildcard":          {"[user.alice]\npassword = \"x\"\npaths = [\"*\", \"docs\"]\n" + paths, ""},
+		"no users":          {paths, ""},
+	}
+
+	for name, test := range tests {
+		_, err := loaded(t, test.config)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got %v, expected %q", name, err, test.err)
+		}
+	}
+}
+
+// The built in top level user is turned off once users are configured,
+// unless it's configured too
+func TestValidateDefaultUser(t *testing.T) {
+	c, err := loaded(t, "[user.alice]\npassword = \"x\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Username != "" {
+		t.Errorf("default user %q is still there", c.Username)
+	}
+	if ok, _ := c.CheckPasswd("scanner", "scanme"); ok {
+		t.Error("the default user can still log in")
+	}
+
+	c, err = loaded(t, "username = \"admin\"\n[user.alice]\npassword = \"x\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Username != "admin" {
+		t.Errorf("configured top level user became %q", c.Username)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	type listener struct {
+		port     int
+		tls      bool
+		explicit bool
+	}
+
+	tests := map[string]struct {
+		config    string
+		listeners []listener
+	}{
+		"plain":                     {"", []listener{{9921, false, false}}},
+		"require_tls without certs": {"require_tls = true", nil},
+		"cert without key":          {`tls_cert = "cert.pem"`, nil},
+		"key without cert":          {`tls_key = "key.pem"`, nil},
+		"tls on the same port":      {"tls_cert = \"cert.pem\"\ntls_key = \"key.pem\"", nil},
+		"tls_port":                  {"tls_cert = \"cert.pem\"\ntls_key = \"key.pem\"\ntls_port = 9990", []listener{{9921, false, false}, {9990, true, true}}},
+		"tls_port same as port":     {"tls_cert = \"cert.pem\"\ntls_key = \"key.pem\"\ntls_port = 9921", nil},
+		"require_tls":               {"tls_cert = \"cert.pem\"\ntls_key = \"key.pem\"\nrequire_tls = true", []listener{{9921, true, true}}},
+		"require_tls on tls_port":   {"tls_cert = \"cert.pem\"\ntls_key = \"key.pem\"\nrequire_tls = true\ntls_port = 9990", []listener{{9990, true, true}}},
+		"explicit":                  {"tls_cert = \"cert.pem\"\ntls_key = \"key.pem\"\nrequire_tls = true\ntls_mode = \"explicit\"", []listener{{9921, true, true}}},
+		"implicit":                  {"tls_cert = \"cert.pem\"\ntls_key = \"key.pem\"\nrequire_tls = true\ntls_mode = \"implicit\"", nil},
+		"unknown tls_mode":          {"tls_cert = \"cert.pem\"\ntls_key = \"key.pem\"\nrequire_tls = true\ntls_mode = \"sometimes\"", nil},
+	}
+
+	for name, test := range tests {
+		c := configuration{Port: 9921}
+		if _, err := toml.Decode(test.config, &c); err != nil {
+			t.Fatal(err)
+		}
+
+		opts, err := c.listeners(nil)
+		if test.listeners == nil {
+			if err == nil {
+				t.Errorf("%s: was accepted", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if len(opts) != len(test.listeners) {
+			t.Errorf("%s: got %d listeners, expected %d", name, len(opts), len(test.listeners))
+			continue
+		}
+		for i, want := range test.listeners {
+			got := listener{opts[i].Port, opts[i].TLS, opts[i].ExplicitFTPS}
+			if got != want {
+				t.Errorf("%s: listener %d is %+v, expected %+v", name, i, got, want)
+			}
+			if got.tls && (opts[i].CertFile != "cert.pem" || opts[i].KeyFile != "key.pem") {
+				t.Errorf("%s: listener %d doesn't have the certificate", name, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package command hands uploads to a local command, either on its stdin or
+// as a file, for integrating with tools that don't warrant a driver of their
+// own
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/freman/scantp/driver/run"
+	"github.com/freman/scantp/driver/sent"
+	"goftp.io/server"
+)
+
+const (
+	defaultTimeout     = 10 * time.Minute
+	defaultConcurrency = 1
+)
+
+type Driver struct {
+	timeout time.Duration
+	slots   chan struct{}
+	// file is set when the command is given the upload as a file rather than
+	// on stdin
+	file bool
+
+	sent *sent.Files
+
+	configuration struct {
+		Command     []string          `toml:"command"`
+		Env         map[string]string `toml:"env"`
+		Dir         string            `toml:"dir"`
+		Timeout     string            `toml:"timeout"`
+		Concurrency int               `toml:"concurrency"`
+	}
+}
+
+func (d *Driver) Stat(p string) (server.FileInfo, error) {
+	return d.sent.Stat(p)
+}
+
+// ListDir is always empty, nothing is kept once the command has it
+func (d *Driver) ListDir(p string, fn func(server.FileInfo) error) error {
+	return nil
+}
+
+// MakeDir succeeds so scanners and templates can use folders, the folder is
+// passed to the command as SCANTP_DIR
+func (d *Driver) MakeDir(p string) error {
+	d.sent.AddDir(p)
+	return nil
+}
+
+func (d *Driver) PutFile(p string, data io.Reader, appendData bool) (int64, error) {
+	return d.PutFileAs("", "", p, data, appendData)
+}
+
+// PutFileAs runs the command for the upload, anything but a zero exit fails
+// the upload
+func (d *Driver) PutFileAs(pathName, user, p string, data io.Reader, appendData bool) (int64, error) {
+	if appendData {
+		return 0, errors.New("Appending to files is not supported")
+	}
+
+	p = path.Clean("/" + p)
+	counter := &sent.CountingReader{Reader: data}
+
+	// Commands that want a file get the upload written out first so they
+	// can seek around in it
+	input := ""
+	var stdin io.Reader = counter
+	if d.file {
+		f, err := ioutil.TempFile("", "scantp-exec-*"+path.Ext(p))
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(f.Name())
+
+		_, err = io.Copy(f, counter)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return 0, err
+		}
+		input, stdin = f.Name(), nil
+	}
+
+	env := []string{
+		"SCANTP_PATH=" + pathName,
+		"SCANTP_USER=" + user,
+		"SCANTP_FILE=" + p,
+		"SCANTP_NAME=" + path.Base(p),
+		"SCANTP_DIR=" + path.Dir(p),
+	}
+	if err := d.run(input, stdin, env); err != nil {
+		return 0, err
+	}
+
+	d.sent.Remember(p, counter.N)
+
+	return counter.N, nil
+}
+
+// run executes the command, waiting for a free slot first so only so many
+// run at once
+func (d *Driver) run(input string, stdin io.Reader, env []string) error {
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+
+	replacer := strings.NewReplacer("{input}", input)
+	args := make([]string, len(d.configuration.Command))
+	for i, arg := range d.configuration.Command {
+		args[i] = replacer.Replace(arg)
+	}
+
+	cmd := &run.Command{
+		Args:    args,
+		Dir:     d.configuration.Dir,
+		Env:     os.Environ(),
+		Stdin:   stdin,
+		Timeout: d.timeout,
+	}
+	for k, v := range d.configuration.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Env = append(cmd.Env, env...)
+
+	return cmd.Run()
+}
+
+func (d *Driver) Rename(from, to string) error {
+	return errors.New("Permission Denied, uploads can't be renamed once they're handed over")
+}
+
+func (d *Driver) DeleteFile(p string) error {
+	return errors.New("Permission Denied, uploads can't be deleted once they're handed over")
+}
+
+func NewDriver(fn func(v interface{}) error) (d *Driver, err error) {
+	d = &Driver{
+		timeout: defaultTimeout,
+		sent:    sent.New(),
+	}
+	if err = fn(&d.configuration); err != nil {
+		return nil, err
+	}
+
+	if len(d.configuration.Command) == 0 {
+		return nil, errors.New("Configuration for exec is invalid, required command")
+	}
+
+	for _, arg := range d.configuration.Command {
+		if strings.Contains(arg, "{input}") {
+			d.file = true
+		}
+	}
+
+	for k := range d.configuration.Env {
+		if k == "" || strings.Contains(k, "=") {
+			return nil, fmt.Errorf("Configuration for exec is invalid, bad env name %q", k)
+		}
+	}
+
+	if d.configuration.Timeout != "" {
+		if d.timeout, err = time.ParseDuration(d.configuration.Timeout); err != nil {
+			return nil, fmt.Errorf("failure while parsing timeout: %w", err)
+		}
+	}
+
+	concurrency := d.configuration.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultConcurrency
+	}
+	if concurrency < 0 {
+		return nil, errors.New("Configuration for exec is invalid, concurrency must be at least 1")
+	}
+	d.slots = make(chan struct{}, concurrency)
+
+	return d, nil
+}
@@ -0,0 +1,108 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func newDriver(t *testing.T, config string) (*Driver, error) {
+	t.Helper()
+
+	var prim toml.Primitive
+	md, err := toml.Decode(config, &prim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewDriver(func(v interface{}) error {
+		return md.PrimitiveDecode(prim, v)
+	})
+}
+
+func TestStdin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scantp-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	d, err := newDriver(t, `command = ["sh", "-c", "cat > `+out+`; echo \"$SCANTP_PATH $SCANTP_USER $SCANTP_FILE $SCANTP_NAME $SCANTP_DIR $EXTRA\" >> `+out+`"]
+env = { EXTRA = "extra" }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := d.PutFileAs("docs", "reception", "/2020/scan.pdf", strings.NewReader("%PDF\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("sent %d bytes, expected 5", n)
+	}
+
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "%PDF\ndocs reception /2020/scan.pdf scan.pdf /2020 extra\n"; string(buf) != want {
+		t.Errorf("command got %q, expected %q", buf, want)
+	}
+
+	if info, err := d.Stat("/2020/scan.pdf"); err != nil || info.Size() != 5 {
+		t.Errorf("stat of the sent file gave %v, %v", info, err)
+	}
+	if info, err := d.Stat("/2020"); err != nil || !info.IsDir() {
+		t.Errorf("stat of the folder it was sent to gave %v, %v", info, err)
+	}
+}
+
+func TestInputFile(t *testing.T) {
+	d, err := newDriver(t, `command = ["sh", "-c", "case {input} in *.pdf) grep -q PDF {input};; *) exit 1;; esac"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.PutFile("/scan.pdf", strings.NewReader("%PDF"), false); err != nil {
+		t.Error(err)
+	}
+	if _, err := d.PutFile("/scan.pdf", strings.NewReader("nope"), false); err == nil {
+		t.Error("upload succeeded when the command failed")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	d, err := newDriver(t, `command = ["sh", "-c", "sleep 10 & sleep 10"]
+timeout = "100ms"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.PutFile("/scan.pdf", strings.NewReader("%PDF"), false); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := d.Stat("/scan.pdf"); err == nil {
+		t.Error("the failed upload was remembered")
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	for _, config := range []string{
+		``,
+		"command = [\"true\"]\nenv = { \"A=B\" = \"c\" }",
+		"command = [\"true\"]\ntimeout = \"soon\"",
+		"command = [\"true\"]\nconcurrency = -1",
+	} {
+		if _, err := newDriver(t, config); err == nil {
+			t.Errorf("%q was accepted", config)
+		}
+	}
+}
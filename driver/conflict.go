@@ -0,0 +1,106 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/freman/scantp/driver/retry"
+)
+
+// conflictPolicy decides what happens when an upload would replace an
+// existing file
+type conflictPolicy string
+
+const (
+	conflictOverwrite conflictPolicy = "overwrite"
+	conflictReject    conflictPolicy = "reject"
+	conflictRename    conflictPolicy = "rename"
+	conflictVersion   conflictPolicy = "version"
+)
+
+// maxConflictAttempts caps how many names are tried looking for a free one
+const maxConflictAttempts = 1000
+
+func parseConflictPolicy(s string) (conflictPolicy, error) {
+	switch p := conflictPolicy(s); p {
+	case "":
+		return conflictOverwrite, nil
+	case conflictOverwrite, conflictReject, conflictRename, conflictVersion:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown on_conflict %q, expected overwrite, reject, rename or version", s)
+}
+
+// resolveConflict applies the conflict policy to an upload, returning the
+// path the upload should actually be written to
+func (vp *virtualPath) resolveConflict(realPath string, appendData bool) (string, error) {
+	if vp.onConflict == conflictOverwrite {
+		return realPath, nil
+	}
+
+	info, err := vp.Stat(realPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return realPath, nil
+		}
+		return "", err
+	}
+
+	if info.IsDir() {
+		return "", retry.Permanent(errors.New("A dir has the same name"))
+	}
+
+	if appendData {
+		return "", retry.Permanent(fmt.Errorf("Appending to existing files is not permitted with on_conflict %q", vp.onConflict))
+	}
+
+	switch vp.onConflict {
+	case conflictRename:
+		return vp.freeName(realPath, func(i int) string {
+			return "-" + strconv.Itoa(i)
+		})
+	case conflictVersion:
+		stamp := info.ModTime().Format("20060102-150405")
+		versioned, err := vp.freeName(realPath, func(i int) string {
+			if i == 1 {
+				return "-" + stamp
+			}
+			return "-" + stamp + "-" + strconv.Itoa(i)
+		})
+		if err != nil {
+			return "", err
+		}
+
+		if err := vp.Driver.Rename(realPath, versioned); err != nil {
+			return "", fmt.Errorf("unable to keep the previous version: %w", err)
+		}
+		return realPath, nil
+	}
+
+	return "", retry.Permanent(fmt.Errorf("%s: %w", realPath, os.ErrExist))
+}
+
+// freeName looks for a name that isn't in use yet by inserting a suffix
+// between the file name and its extension
+func (vp *virtualPath) freeName(p string, suffix func(int) string) (string, error) {
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+
+	for i := 1; i <= maxConflictAttempts; i++ {
+		candidate := base + suffix(i) + ext
+		_, err := vp.Stat(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("unable to find a free name for %s", p)
+}
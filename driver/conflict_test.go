@@ -0,0 +1,134 @@
+package driver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// conflicted makes a path with the given policy and a scan.pdf already in it
+func conflicted(t *testing.T, policy string) (*MultipleDriver, string) {
+	t.Helper()
+
+	root := tempDir(t)
+	factory := configured(t, nil, `
+[path.docs]
+type = "fs"
+root = "`+root+`"
+on_conflict = "`+policy+`"
+`)
+	if err := ioutil.WriteFile(filepath.Join(root, "scan.pdf"), []byte("theirs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return session(t, factory), root
+}
+
+func TestConflictOverwrite(t *testing.T) {
+	for _, policy := range []string{"", "overwrite"} {
+		d, root := conflicted(t, policy)
+		if _, err := d.PutFile("/docs/scan.pdf", strings.NewReader("mine"), false); err != nil {
+			t.Fatal(err)
+		}
+		if got := content(t, filepath.Join(root, "scan.pdf")); got != "mine" {
+			t.Errorf("%q: file is %q", policy, got)
+		}
+	}
+}
+
+func TestConflictReject(t *testing.T) {
+	d, root := conflicted(t, "reject")
+
+	_, err := d.PutFile("/docs/scan.pdf", strings.NewReader("mine"), false)
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("upload gave %v", err)
+	}
+	if got := content(t, filepath.Join(root, "scan.pdf")); got != "theirs" {
+		t.Errorf("file is %q", got)
+	}
+
+	// Anything else still goes through
+	if _, err := d.PutFile("/docs/other.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConflictRename(t *testing.T) {
+	d, root := conflicted(t, "rename")
+
+	for _, want := range []string{"scan-1.pdf", "scan-2.pdf"} {
+		if _, err := d.PutFile("/docs/scan.pdf", strings.NewReader(want), false); err != nil {
+			t.Fatal(err)
+		}
+		if got := content(t, filepath.Join(root, want)); got != want {
+			t.Errorf("%s is %q", want, got)
+		}
+	}
+	if got := content(t, filepath.Join(root, "scan.pdf")); got != "theirs" {
+		t.Errorf("file is %q", got)
+	}
+}
+
+func TestConflictVersion(t *testing.T) {
+	d, root := conflicted(t, "version")
+
+	modified := time.Date(2020, 3, 14, 9, 26, 53, 0, time.Local)
+	if err := os.Chtimes(filepath.Join(root, "scan.pdf"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.PutFile("/docs/scan.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := content(t, filepath.Join(root, "scan.pdf")); got != "mine" {
+		t.Errorf("file is %q", got)
+	}
+	if got := content(t, filepath.Join(root, "scan-20200314-092653.pdf")); got != "theirs" {
+		t.Errorf("previous version is %q", got)
+	}
+}
+
+// Appending is how an upload continues, so it's only refused when the file
+// isn't one this session wrote
+func TestConflictAppend(t *testing.T) {
+	for _, policy := range []string{"reject", "rename", "version"} {
+		d, root := conflicted(t, policy)
+
+		if _, err := d.PutFile("/docs/scan.pdf", strings.NewReader(" more"), true); err == nil {
+			t.Errorf("%s: appended to someone else's file", policy)
+		}
+		if got := content(t, filepath.Join(root, "scan.pdf")); got != "theirs" {
+			t.Errorf("%s: file is %q", policy, got)
+		}
+
+		if _, err := d.PutFile("/docs/new.pdf", strings.NewReader("mine"), false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.PutFile("/docs/new.pdf", strings.NewReader(" more"), true); err != nil {
+			t.Errorf("%s: %v", policy, err)
+		}
+		if got := content(t, filepath.Join(root, "new.pdf")); got != "mine more" {
+			t.Errorf("%s: own file is %q", policy, got)
+		}
+	}
+}
+
+func TestConflictDirectory(t *testing.T) {
+	d, root := conflicted(t, "rename")
+	if err := os.Mkdir(filepath.Join(root, "2020"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.PutFile("/docs/2020", strings.NewReader("mine"), false); err == nil {
+		t.Error("uploaded over a directory")
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	if _, err := parseConflictPolicy("sometimes"); err == nil {
+		t.Error("unknown policy was accepted")
+	}
+}
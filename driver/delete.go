@@ -0,0 +1,25 @@
+package driver
+
+import (
+	"fmt"
+)
+
+// deletePolicy decides which files a session may delete, some scanners test
+// a destination by uploading a file then deleting it again
+type deletePolicy string
+
+const (
+	deleteNone    deletePolicy = "none"
+	deleteSession deletePolicy = "session"
+)
+
+func parseDeletePolicy(s string) (deletePolicy, error) {
+	switch p := deletePolicy(s); p {
+	case "":
+		return deleteNone, nil
+	case deleteNone, deleteSession:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown allow_delete %q, expected none or session", s)
+}
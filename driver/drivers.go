@@ -4,23 +4,44 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/freman/scantp/driver/command"
+	"github.com/freman/scantp/driver/email"
 	"github.com/freman/scantp/driver/filesystem"
+	"github.com/freman/scantp/driver/ftp"
+	"github.com/freman/scantp/driver/httppost"
+	"github.com/freman/scantp/driver/notify"
+	"github.com/freman/scantp/driver/paperless"
+	"github.com/freman/scantp/driver/processor"
+	"github.com/freman/scantp/driver/s3"
 	"github.com/freman/scantp/driver/seafile"
+	"github.com/freman/scantp/driver/sftp"
+	"github.com/freman/scantp/driver/webdav"
+	"github.com/sirupsen/logrus"
 	"goftp.io/server"
 )
 
 func (factory *MultipleDriverFactory) AddPath(name, driverName string, md toml.MetaData, primative toml.Primitive) error {
 	if factory.drivers == nil {
-		factory.drivers = make(map[string]Driver)
+		factory.drivers = make(map[string]*virtualPath)
 	}
 
 	if _, exists := factory.drivers[name]; exists {
 		return fmt.Errorf("paths must be unique %q is already used", name)
 	}
 
+	var options pathOptions
+	if err := md.PrimitiveDecode(primative, &options); err != nil {
+		return err
+	}
+
 	subDriver, err := factory.subDriver(driverName, func(v interface{}) error {
 		return md.PrimitiveDecode(primative, v)
 	})
@@ -28,28 +49,251 @@ func (factory *MultipleDriverFactory) AddPath(name, driverName string, md toml.M
 		return err
 	}
 
-	factory.drivers[name] = subDriver
+	processors := make(processor.Chain, len(options.Processors))
+	for i, primative := range options.Processors {
+		if processors[i], err = processor.New(func(v interface{}) error {
+			return md.PrimitiveDecode(primative, v)
+		}); err != nil {
+			return fmt.Errorf("path %s: %w", name, err)
+		}
+	}
+
+	notifiers := make(notify.List, len(options.Notify))
+	for i, primative := range options.Notify {
+		if notifiers[i], err = notify.New(func(v interface{}) error {
+			return md.PrimitiveDecode(primative, v)
+		}); err != nil {
+			return fmt.Errorf("path %s: %w", name, err)
+		}
+	}
+
+	vp, err := newVirtualPath(name, subDriver, options, processors, notifiers)
+	if err != nil {
+		return err
+	}
+
+	vp.driverName = driverName
+	vp.route = factory.path
+	factory.drivers[name] = vp
 	return nil
 }
 
+// path looks up a virtual path by name for a user, paths the user isn't
+// allowed to use are treated as if they don't exist. Paths are only added
+// while the configuration is loaded so this is safe once the server is
+// running
+func (factory *MultipleDriverFactory) path(name, user string) (*virtualPath, bool) {
+	vp, exists := factory.drivers[name]
+	if !exists {
+		return nil, false
+	}
+
+	if factory.Authorizer != nil && (user == "" || !factory.Authorizer.Allowed(user, name)) {
+		return nil, false
+	}
+
+	return vp, true
+}
+
 func (factory *MultipleDriverFactory) subDriver(name string, fn func(v interface{}) error) (Driver, error) {
 	switch name {
 	case `seafile`:
 		return seafile.NewDriver(fn)
 	case `fs`, `filesystem`, `local`:
 		return filesystem.NewDriver(fn)
+	case `s3`:
+		return s3.NewDriver(fn)
+	case `webdav`:
+		return webdav.NewDriver(fn)
+	case `smtp`, `email`:
+		return email.NewDriver(fn)
+	case `http`:
+		return httppost.NewDriver(fn)
+	case `paperless`:
+		return paperless.NewDriver(fn)
+	case `exec`:
+		return command.NewDriver(fn)
+	case `ftp`:
+		return ftp.NewDriver(fn)
+	case `sftp`:
+		return sftp.NewDriver(fn)
 	}
 
 	return nil, fmt.Errorf("unknown driver %q", name)
 }
 
+// Authorizer decides which virtual paths a logged in user may use
+type Authorizer interface {
+	Allowed(username, pathName string) bool
+}
+
 var _ server.Driver = &MultipleDriver{}
 
 type MultipleDriver struct {
-	drivers map[string]Driver
+	drivers    map[string]*virtualPath
+	authorizer Authorizer
+	conn       *server.Conn
+	session    string
+	// remote is the address of the scanner, it's only known once Init has
+	// been called
+	remote string
+
+	// uploaded is every file written by this session, keyed by the virtual
+	// path it was uploaded as
+	mu       sync.Mutex
+	uploaded map[string]*upload
+}
+
+// upload is a file written by this session
+type upload struct {
+	at time.Time
+	// realPath is where it was uploaded to after applying templates, it's
+	// only where it was written if nothing on the way moved it
+	realPath string
+
+	mu sync.Mutex
+	// files are where it was written once it has been, the conflict policy
+	// and processors can change its name, split it up or route it to
+	// another path
+	files []uploadedFile
+}
+
+type uploadedFile struct {
+	vp       *virtualPath
+	realPath string
+}
+
+// wrote records where the upload was written, it's safe to call on uploads
+// nobody is keeping track of
+func (u *upload) wrote(vp *virtualPath, realPath string) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, f := range u.files {
+		if f.vp == vp && f.realPath == realPath {
+			return
+		}
+	}
+	u.files = append(u.files, uploadedFile{vp: vp, realPath: realPath})
+}
+
+// moved records the upload being renamed after it was written
+func (u *upload) moved(vp *virtualPath, from, to string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i, f := range u.files {
+		if f.vp == vp && f.realPath == from {
+			u.files[i].realPath = to
+		}
+	}
+}
+
+// written is where the upload was written
+func (u *upload) written() []uploadedFile {
+	if u == nil {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]uploadedFile(nil), u.files...)
+}
+
+// in finds where the upload was written in a path
+func (u *upload) in(vp *virtualPath) (string, bool) {
+	for _, f := range u.written() {
+		if f.vp == vp {
+			return f.realPath, true
+		}
+	}
+	return "", false
+}
+
+// Init is called by the server with the connection this driver is serving,
+// which is how we find out who is logged in and from where. The session
+// takes on the server's id for it so the server's logging and ours can be
+// matched up
+func (driver *MultipleDriver) Init(conn *server.Conn) {
+	driver.conn = conn
+	if id := conn.SessionID(); id != "" {
+		driver.session = id
+	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		driver.remote = addr.String()
+		if host, _, err := net.SplitHostPort(driver.remote); err == nil {
+			driver.remote = host
+		}
+	}
+}
+
+// log carries the details of the session, the user is only known once
+// they've logged in
+func (driver *MultipleDriver) log() *logrus.Entry {
+	fields := logrus.Fields{"session": driver.session}
+	if driver.remote != "" {
+		fields["remote"] = driver.remote
+	}
+	if user := driver.user(); user != "" {
+		fields["user"] = user
+	}
+	return logrus.WithFields(fields)
+}
+
+// logged logs an operation on a file along with the virtual path and driver
+// it belongs to, failures are logged as warnings unless the operation is
+// only worth logging when debugging
+func (driver *MultipleDriver) logged(level logrus.Level, op, path string, err error, fields logrus.Fields) {
+	entry := driver.log().WithFields(logrus.Fields{"op": op, "file": path})
+	if driverName, _ := driverPrefix(path); driverName != "" {
+		if vp, isa := driver.drivers[driverName]; isa {
+			entry = entry.WithFields(logrus.Fields{"path": driverName, "driver": vp.driverName})
+		}
+	}
+	entry = entry.WithFields(fields)
+
+	if err != nil {
+		if level < logrus.DebugLevel {
+			level = logrus.WarnLevel
+		}
+		entry.WithError(err).Log(level, op+" failed")
+		return
+	}
+	entry.Log(level, op)
+}
+
+// subDriver finds the driver for a virtual path, paths the logged in user
+// isn't allowed to use are treated as if they don't exist
+func (driver *MultipleDriver) subDriver(name string) (*virtualPath, bool) {
+	subDriver, isa := driver.drivers[name]
+	if !isa || !driver.allowed(name) {
+		return nil, false
+	}
+	return subDriver, true
+}
+
+func (driver *MultipleDriver) allowed(name string) bool {
+	if driver.authorizer == nil {
+		return true
+	}
+
+	// Without a connection we can't know who is asking, so don't allow anything
+	if driver.conn == nil || !driver.conn.IsLogin() {
+		return false
+	}
+
+	return driver.authorizer.Allowed(driver.conn.LoginUser(), name)
 }
 
 func (driver *MultipleDriver) Stat(path string) (server.FileInfo, error) {
+	info, err := driver.stat(path)
+	driver.logged(logrus.DebugLevel, "stat", path, err, nil)
+	return info, err
+}
+
+func (driver *MultipleDriver) stat(path string) (server.FileInfo, error) {
 	driverName, realPath := driverPrefix(path)
 	if driverName == "" || driverName == "/" {
 		return &fileInfo{
@@ -57,24 +301,38 @@ func (driver *MultipleDriver) Stat(path string) (server.FileInfo, error) {
 		}, nil
 	}
 
-	if subDriver, isa := driver.drivers[driverName]; isa {
+	if subDriver, isa := driver.subDriver(driverName); isa {
 		if realPath == "" || realPath == "/" {
 			return &fileInfo{
 				name: driverName,
 			}, nil
 		}
 
-		return subDriver.Stat(realPath)
+		// Scanners check their uploads, which may have been written somewhere
+		// else by the templates, conflict policy or processors
+		if uploaded, isa := driver.uploadedAs(driverName + realPath); isa {
+			return subDriver.statUpload(uploaded)
+		}
+
+		return subDriver.stat(realPath)
 	}
 
 	return nil, errors.New("Not a file")
 }
 
 func (driver *MultipleDriver) ListDir(path string, callback func(server.FileInfo) error) error {
+	err := driver.listDir(path, callback)
+	driver.logged(logrus.DebugLevel, "list", path, err, nil)
+	return err
+}
+
+func (driver *MultipleDriver) listDir(path string, callback func(server.FileInfo) error) error {
 	driverName, realPath := driverPrefix(path)
-	fmt.Println(">", driverName, realPath)
 	if driverName == "" || driverName == "/" {
 		for name := range driver.drivers {
+			if !driver.allowed(name) {
+				continue
+			}
 			if err := callback(&fileInfo{
 				name: name,
 			}); err != nil {
@@ -84,62 +342,239 @@ func (driver *MultipleDriver) ListDir(path string, callback func(server.FileInfo
 		return nil
 	}
 
-	if subDriver, isa := driver.drivers[driverName]; isa {
+	if subDriver, isa := driver.subDriver(driverName); isa {
 		return subDriver.ListDir(realPath, callback)
 	}
 
 	return errors.New("Not path with that name configured")
 }
 
-func (driver *MultipleDriver) DeleteDir(string) error {
-	return errors.New("Permission Denied")
+func (driver *MultipleDriver) DeleteDir(path string) error {
+	err := errors.New("Permission Denied")
+	driver.logged(logrus.InfoLevel, "rmdir", path, err, nil)
+	return err
+}
+
+func (driver *MultipleDriver) DeleteFile(path string) error {
+	err := driver.deleteFile(path)
+	driver.logged(logrus.InfoLevel, "delete", path, err, nil)
+	return err
+}
+
+// deleteFile only lets a session delete files it uploaded itself, and only
+// on paths that opt in to it
+func (driver *MultipleDriver) deleteFile(path string) error {
+	driverName, realPath := driverPrefix(path)
+	if driverName == "" || driverName == "/" || realPath == "/" {
+		return errors.New("Virtual file system, not writable")
+	}
+
+	subDriver, isa := driver.subDriver(driverName)
+	if !isa {
+		return errors.New("Not path with that name configured")
+	}
+
+	if subDriver.allowDelete != deleteSession {
+		return errors.New("Permission Denied")
+	}
+
+	uploaded, isa := driver.uploadedAs(driverName + realPath)
+	if !isa {
+		return errors.New("Permission Denied, file wasn't uploaded in this session")
+	}
+
+	if subDriver.deleteAfter > 0 && time.Since(uploaded.at) > subDriver.deleteAfter {
+		return errors.New("Permission Denied, file was uploaded too long ago")
+	}
+
+	if err := subDriver.deleteUpload(uploaded); err != nil {
+		return err
+	}
+
+	driver.mu.Lock()
+	delete(driver.uploaded, driverName+realPath)
+	driver.mu.Unlock()
+
+	return nil
+}
+
+func (driver *MultipleDriver) Rename(fromPath, toPath string) error {
+	err := driver.rename(fromPath, toPath)
+	driver.logged(logrus.InfoLevel, "rename", fromPath, err, logrus.Fields{"to": toPath})
+	return err
+}
+
+// rename is limited to a single virtual path, and by default to files that
+// were uploaded in this session
+func (driver *MultipleDriver) rename(fromPath, toPath string) error {
+	driverName, fromReal := driverPrefix(fromPath)
+	toDriverName, toReal := driverPrefix(toPath)
+	if driverName == "" || driverName == "/" || fromReal == "/" || toReal == "/" {
+		return errors.New("Virtual file system, not writable")
+	}
+
+	if driverName != toDriverName {
+		return errors.New("Permission Denied, can't rename between paths")
+	}
+
+	subDriver, isa := driver.subDriver(driverName)
+	if !isa {
+		return errors.New("Not path with that name configured")
+	}
+
+	uploaded, isa := driver.uploadedAs(driverName + fromReal)
+	switch subDriver.allowRename {
+	case renameNone:
+		return errors.New("Permission Denied")
+	case renameSession:
+		if !isa {
+			return errors.New("Permission Denied, file wasn't uploaded in this session")
+		}
+	}
+
+	if !isa {
+		_, err := subDriver.rename(fromReal, toReal)
+		return err
+	}
+
+	// Files from this session were written where the templates said, so the
+	// new name goes through them too as if it had been uploaded that way
+	destPath, err := subDriver.destination(toReal, driver.user(), uploaded.at)
+	if err != nil {
+		return err
+	}
+
+	if err := subDriver.renameUpload(uploaded, destPath); err != nil {
+		return err
+	}
+
+	driver.mu.Lock()
+	delete(driver.uploaded, driverName+fromReal)
+	driver.uploaded[driverName+toReal] = uploaded
+	driver.mu.Unlock()
+
+	return nil
 }
 
-func (driver *MultipleDriver) DeleteFile(string) error {
-	return errors.New("Permission Denied")
+func (driver *MultipleDriver) uploadedAs(name string) (*upload, bool) {
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+	uploaded, isa := driver.uploaded[name]
+	return uploaded, isa
 }
 
-func (driver *MultipleDriver) Rename(string, string) error {
-	return errors.New("Permission Denied")
+func (driver *MultipleDriver) user() string {
+	if driver.conn == nil {
+		return ""
+	}
+	return driver.conn.LoginUser()
 }
 
 func (driver *MultipleDriver) MakeDir(path string) error {
+	err := driver.makeDir(path)
+	driver.logged(logrus.InfoLevel, "mkdir", path, err, nil)
+	return err
+}
+
+func (driver *MultipleDriver) makeDir(path string) error {
 	driverName, realPath := driverPrefix(path)
 	if driverName == "" || driverName == "/" {
 		return errors.New("Virtual file system, not writable")
 	}
 
-	if subDriver, isa := driver.drivers[driverName]; isa {
+	if subDriver, isa := driver.subDriver(driverName); isa {
 		return subDriver.MakeDir(realPath)
 	}
 
 	return errors.New("Not path with that name configured")
 }
 
-func (driver *MultipleDriver) GetFile(string, int64) (int64, io.ReadCloser, error) {
-	return 0, nil, errors.New("Permission Denied")
+func (driver *MultipleDriver) GetFile(path string, offset int64) (int64, io.ReadCloser, error) {
+	err := errors.New("Permission Denied")
+	driver.logged(logrus.InfoLevel, "download", path, err, nil)
+	return 0, nil, err
 }
 
 // PutFile implements Driver
 func (driver *MultipleDriver) PutFile(destPath string, data io.Reader, appendData bool) (int64, error) {
+	started := time.Now()
+	n, err := driver.putFile(destPath, data, appendData)
+	driver.logged(logrus.InfoLevel, "upload", destPath, err, logrus.Fields{
+		"bytes":    n,
+		"append":   appendData,
+		"duration": time.Since(started).String(),
+	})
+	if err != nil {
+		driver.notifyFailure(destPath, n, err)
+	}
+	return n, err
+}
+
+// notifyFailure tells the path's notifiers an upload didn't make it, ones
+// that did are notified once they've been delivered
+func (driver *MultipleDriver) notifyFailure(destPath string, n int64, err error) {
+	driverName, realPath := driverPrefix(destPath)
+	subDriver, isa := driver.subDriver(driverName)
+	if !isa {
+		return
+	}
+
+	subDriver.notifiers.Notify(notify.Event{
+		Path:    driverName,
+		File:    realPath,
+		User:    driver.user(),
+		Session: driver.session,
+		Bytes:   n,
+		Error:   err.Error(),
+	})
+}
+
+func (driver *MultipleDriver) putFile(destPath string, data io.Reader, appendData bool) (int64, error) {
 	driverName, realPath := driverPrefix(destPath)
 	if driverName == "" || driverName == "/" {
 		return 0, errors.New("Virtual file system, not writable")
 	}
 
-	if subDriver, isa := driver.drivers[driverName]; isa {
-		return subDriver.PutFile(realPath, data, appendData)
+	if subDriver, isa := driver.subDriver(driverName); isa {
+		// Appending carries on with the file from earlier in the session
+		// rather than starting a new one
+		uploaded, isa := driver.uploadedAs(driverName + realPath)
+		if !isa || !appendData {
+			uploaded = &upload{at: time.Now()}
+			var err error
+			if uploaded.realPath, err = subDriver.destination(realPath, driver.user(), uploaded.at); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := subDriver.put(driver.session, source{User: driver.user(), Session: driver.session, upload: uploaded}, uploaded.realPath, data, appendData)
+		if err == nil {
+			driver.mu.Lock()
+			driver.uploaded[driverName+realPath] = uploaded
+			driver.mu.Unlock()
+		}
+		return n, err
 	}
 
 	return 0, errors.New("unknown driver")
 }
 
 type MultipleDriverFactory struct {
-	drivers map[string]Driver
+	drivers  map[string]*virtualPath
+	sessions uint64
+
+	// Authorizer restricts which paths each user may use, all paths are
+	// available to everyone if it's nil
+	Authorizer Authorizer
 }
 
 func (factory *MultipleDriverFactory) NewDriver() (server.Driver, error) {
-	return &MultipleDriver{factory.drivers}, nil
+	return &MultipleDriver{
+		drivers:    factory.drivers,
+		authorizer: factory.Authorizer,
+		session:    strconv.FormatUint(atomic.AddUint64(&factory.sessions, 1), 10),
+		uploaded:   make(map[string]*upload),
+	}, nil
 }
 
 func driverPrefix(path string) (driverName, realPath string) {
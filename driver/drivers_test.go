@@ -0,0 +1,422 @@
+package driver
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	ftpc "github.com/jlaffaye/ftp"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"goftp.io/server"
+)
+
+// allowEveryone would let anyone use any path, if it were asked
+type allowEveryone struct{}
+
+func (allowEveryone) Allowed(username, pathName string) bool {
+	return true
+}
+
+// testFactory builds a factory with a single filesystem path called docs
+func testFactory(t *testing.T, authorizer Authorizer) (*MultipleDriverFactory, string) {
+	t.Helper()
+
+	root, err := ioutil.TempDir("", "scantp-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	var config struct {
+		Paths map[string]toml.Primitive `toml:"path"`
+	}
+	md, err := toml.Decode("[path.docs]\ntype = \"fs\"\nroot = "+`"`+filepath.ToSlash(root)+`"`, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factory := &MultipleDriverFactory{Authorizer: authorizer}
+	if err := factory.AddPath("docs", "fs", md, config.Paths["docs"]); err != nil {
+		t.Fatal(err)
+	}
+
+	return factory, root
+}
+
+// Without Init the driver can't know who is logged in, which must not be
+// mistaken for someone allowed to use everything
+func TestAllowedFailsClosedWithoutInit(t *testing.T) {
+	factory, root := testFactory(t, allowEveryone{})
+
+	sd, err := factory.NewDriver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := sd.(*MultipleDriver)
+
+	var names []string
+	if err := d.ListDir("/", func(info server.FileInfo) error {
+		names = append(names, info.Name())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Errorf("listed %v without knowing who is logged in", names)
+	}
+
+	if _, err := d.Stat("/docs"); err == nil {
+		t.Error("stat succeeded without knowing who is logged in")
+	}
+
+	if _, err := d.PutFile("/docs/scan.pdf", bytes.NewReader([]byte("%PDF")), false); err == nil {
+		t.Error("upload succeeded without knowing who is logged in")
+	}
+	if _, err := os.Stat(filepath.Join(root, "scan.pdf")); err == nil {
+		t.Error("upload was written without knowing who is logged in")
+	}
+}
+
+func TestAllowedWithoutAuthorizer(t *testing.T) {
+	factory, root := testFactory(t, nil)
+
+	sd, err := factory.NewDriver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sd.PutFile("/docs/scan.pdf", bytes.NewReader([]byte("%PDF")), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "scan.pdf")); err != nil {
+		t.Error(err)
+	}
+}
+
+// session starts a new session on the factory
+func session(t *testing.T, factory *MultipleDriverFactory) *MultipleDriver {
+	t.Helper()
+
+	sd, err := factory.NewDriver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sd.(*MultipleDriver)
+}
+
+// delivered waits for a path's spool to empty
+func delivered(t *testing.T, vp *virtualPath) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); vp.spool.depth() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("spool never emptied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// hold keeps a path's spool busy delivering another file until the returned
+// func is called, so whatever is uploaded meanwhile stays waiting
+func hold(t *testing.T, vp *virtualPath) func() {
+	t.Helper()
+
+	release := make(chan struct{})
+	deliver := vp.spool.deliver
+	vp.spool.deliver = func(src source, realPath string, data io.Reader, appendData bool) (int64, error) {
+		<-release
+		return deliver(src, realPath, data, appendData)
+	}
+
+	if _, err := vp.spool.PutFile(source{}, "/held.pdf", strings.NewReader("held"), false); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		vp.spool.mu.Lock()
+		active := vp.spool.active
+		vp.spool.mu.Unlock()
+		if active != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var once sync.Once
+	done := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(done)
+	return done
+}
+
+func content(t *testing.T, name string) string {
+	t.Helper()
+
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+// Uploads the conflict policy gave another name are the ones deleted, not
+// the file that was already there
+func TestDeleteAfterConflictRename(t *testing.T) {
+	root := tempDir(t)
+	factory := configured(t, nil, `
+[path.docs]
+type = "fs"
+root = "`+root+`"
+on_conflict = "rename"
+allow_delete = "session"
+`)
+	if err := ioutil.WriteFile(filepath.Join(root, "scan.pdf"), []byte("someone else's"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := session(t, factory)
+	if _, err := d.PutFile("/docs/scan.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PutFile("/docs/scan.pdf", strings.NewReader(" too"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := content(t, filepath.Join(root, "scan-1.pdf")); got != "mine too" {
+		t.Errorf("upload is %q", got)
+	}
+
+	info, err := d.Stat("/docs/scan.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len("mine too")) {
+		t.Errorf("stat gave %d bytes", info.Size())
+	}
+
+	if err := d.DeleteFile("/docs/scan.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(t, filepath.Join(root, "scan-1.pdf")) {
+		t.Error("upload wasn't deleted")
+	}
+	if got := content(t, filepath.Join(root, "scan.pdf")); got != "someone else's" {
+		t.Errorf("deleted someone else's file, it's now %q", got)
+	}
+}
+
+// Processors renaming an upload don't leave deletes pointing at the name it
+// was uploaded as
+func TestDeleteAfterProcessing(t *testing.T) {
+	root, spool := tempDir(t), tempDir(t)
+	factory := configured(t, nil, `
+[path.gzipped]
+type = "fs"
+root = "`+root+`"
+allow_delete = "session"
+spool = "`+spool+`"
+
+[[path.gzipped.processor]]
+type = "gzip"
+`)
+	if err := ioutil.WriteFile(filepath.Join(root, "scan.pdf"), []byte("someone else's"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := session(t, factory)
+	if _, err := d.PutFile("/gzipped/scan.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+	delivered(t, factory.drivers["gzipped"])
+
+	if !exists(t, filepath.Join(root, "scan.pdf.gz")) {
+		t.Fatal("upload wasn't compressed")
+	}
+	if err := d.DeleteFile("/gzipped/scan.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(t, filepath.Join(root, "scan.pdf.gz")) {
+		t.Error("upload wasn't deleted")
+	}
+	if !exists(t, filepath.Join(root, "scan.pdf")) {
+		t.Error("deleted someone else's file")
+	}
+}
+
+// Deleting an upload still in the spool drops it there, other uploads with
+// the same name are left alone
+func TestDeleteSpooled(t *testing.T) {
+	root, spool := tempDir(t), tempDir(t)
+	factory := configured(t, nil, `
+[path.queued]
+type = "fs"
+root = "`+root+`"
+allow_delete = "session"
+spool = "`+spool+`"
+`)
+	vp := factory.drivers["queued"]
+
+	// Hold deliveries up so both uploads are still waiting
+	release := hold(t, vp)
+
+	mine, theirs := session(t, factory), session(t, factory)
+	if _, err := theirs.PutFile("/queued/scan.pdf", strings.NewReader("theirs"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mine.PutFile("/queued/scan.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := mine.DeleteFile("/queued/scan.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	delivered(t, vp)
+
+	if got := content(t, filepath.Join(root, "scan.pdf")); got != "theirs" {
+		t.Errorf("delivered %q", got)
+	}
+}
+
+// Scanners that upload to a temporary name rename the file they wrote, not
+// whatever else was there under that name
+func TestRenameAfterConflictRename(t *testing.T) {
+	root := tempDir(t)
+	factory := configured(t, nil, `
+[path.docs]
+type = "fs"
+root = "`+root+`"
+on_conflict = "rename"
+`)
+	if err := ioutil.WriteFile(filepath.Join(root, "~tmp1.pdf"), []byte("someone else's"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := session(t, factory)
+	if _, err := d.PutFile("/docs/~tmp1.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Rename("/docs/~tmp1.pdf", "/docs/scan.pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := content(t, filepath.Join(root, "scan.pdf")); got != "mine" {
+		t.Errorf("renamed %q", got)
+	}
+	if got := content(t, filepath.Join(root, "~tmp1.pdf")); got != "someone else's" {
+		t.Errorf("someone else's file is now %q", got)
+	}
+	if info, err := d.Stat("/docs/scan.pdf"); err != nil || info.Size() != 4 {
+		t.Errorf("stat after rename: %v", err)
+	}
+}
+
+func TestRenameSpooled(t *testing.T) {
+	root, spool := tempDir(t), tempDir(t)
+	factory := configured(t, nil, `
+[path.renamed]
+type = "fs"
+root = "`+root+`"
+spool = "`+spool+`"
+`)
+	vp := factory.drivers["renamed"]
+	release := hold(t, vp)
+
+	d := session(t, factory)
+	if _, err := d.PutFile("/renamed/~tmp1.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Rename("/renamed/~tmp1.pdf", "/renamed/scan.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	delivered(t, vp)
+
+	if got := content(t, filepath.Join(root, "scan.pdf")); got != "mine" {
+		t.Errorf("delivered %q", got)
+	}
+	if exists(t, filepath.Join(root, "~tmp1.pdf")) {
+		t.Error("delivered under the temporary name")
+	}
+}
+
+// sessionLogger records the sessions the ftp server logged
+type sessionLogger struct {
+	server.DiscardLogger
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func (l *sessionLogger) PrintCommand(sessionID string, command string, params string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ids[sessionID] = true
+}
+
+// The driver logs where the scanner is connecting from, under the same
+// session id as the ftp server's own logging
+func TestSessionLogging(t *testing.T) {
+	factory, root := testFactory(t, nil)
+
+	hook := logtest.NewGlobal()
+	t.Cleanup(func() { logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks)) })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := &sessionLogger{ids: make(map[string]bool)}
+	srv := server.NewServer(&server.ServerOpts{
+		Factory:  factory,
+		Auth:     &server.SimpleAuth{Name: "scanner", Password: "scanme"},
+		Hostname: "127.0.0.1",
+		Port:     l.Addr().(*net.TCPAddr).Port,
+		Logger:   logger,
+	})
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Shutdown() })
+
+	c, err := ftpc.Dial(l.Addr().String(), ftpc.DialWithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("scanner", "scanme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stor("/docs/scan.pdf", strings.NewReader("scanned")); err != nil {
+		t.Fatal(err)
+	}
+	c.Quit()
+
+	if got := content(t, filepath.Join(root, "scan.pdf")); got != "scanned" {
+		t.Fatalf("upload is %q", got)
+	}
+
+	var logged bool
+	for _, entry := range hook.AllEntries() {
+		session, isa := entry.Data["session"].(string)
+		if !isa {
+			continue
+		}
+		logged = true
+
+		logger.mu.Lock()
+		known := logger.ids[session]
+		logger.mu.Unlock()
+		if !known {
+			t.Errorf("%q was logged under session %s which the server doesn't know", entry.Message, session)
+		}
+		if entry.Data["remote"] != "127.0.0.1" {
+			t.Errorf("%q was logged from %v", entry.Message, entry.Data["remote"])
+		}
+	}
+	if !logged {
+		t.Error("the upload wasn't logged")
+	}
+}
@@ -0,0 +1,388 @@
+// Package email delivers uploads as attachments through an SMTP relay,
+// recipients show up as folders so where a file is uploaded picks who it's
+// sent to
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/freman/scantp/driver/pdf"
+	"github.com/freman/scantp/driver/sent"
+	"goftp.io/server"
+)
+
+const (
+	defaultSubject = `Scan: {{.Name}}{{if gt .Parts 1}} ({{.Part}} of {{.Parts}}){{end}}`
+	defaultBody    = `{{.Name}} is attached.`
+)
+
+type Driver struct {
+	relay   *Relay
+	subject *template.Template
+	body    *template.Template
+	maxSize int64
+
+	sent *sent.Files
+
+	configuration struct {
+		RelayConfig
+		From       string              `toml:"from"`
+		To         []string            `toml:"to"`
+		Recipients map[string][]string `toml:"recipients"`
+		Subject    string              `toml:"subject"`
+		Body       string              `toml:"body"`
+		MaxSize    int64               `toml:"max_size"` // MiB
+		Oversize   string              `toml:"oversize"`
+	}
+}
+
+// recipients works out who a file is sent to from its folder, files at the
+// top go to the default recipients
+func (d *Driver) recipients(p string) (string, []string, bool) {
+	folder := strings.SplitN(strings.TrimPrefix(path.Clean("/"+p), "/"), "/", 2)
+	if len(folder) == 1 {
+		return "", d.configuration.To, len(d.configuration.To) > 0
+	}
+
+	to, isa := d.configuration.Recipients[folder[0]]
+	return folder[0], to, isa
+}
+
+func (d *Driver) Stat(p string) (server.FileInfo, error) {
+	if _, isa := d.configuration.Recipients[strings.Trim(path.Clean("/"+p), "/")]; isa {
+		return sent.Dir(path.Base(p)), nil
+	}
+	return d.sent.Stat(p)
+}
+
+// ListDir shows the recipients as folders, nothing is kept once it's sent
+func (d *Driver) ListDir(p string, fn func(server.FileInfo) error) error {
+	if name := strings.Trim(path.Clean("/"+p), "/"); name != "" {
+		if _, isa := d.configuration.Recipients[name]; !isa {
+			return fmt.Errorf("%s: %w", p, os.ErrNotExist)
+		}
+		return nil
+	}
+
+	for name := range d.configuration.Recipients {
+		if err := fn(sent.Dir(name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MakeDir succeeds for recipients so scanners that insist on creating the
+// folder they upload to can
+func (d *Driver) MakeDir(p string) error {
+	if _, isa := d.configuration.Recipients[strings.Trim(path.Clean("/"+p), "/")]; isa {
+		return nil
+	}
+	return errors.New("Permission Denied, folders are recipients")
+}
+
+func (d *Driver) PutFile(p string, data io.Reader, appendData bool) (int64, error) {
+	if appendData {
+		return 0, errors.New("Appending to files is not supported")
+	}
+
+	folder, to, isa := d.recipients(p)
+	if !isa {
+		return 0, fmt.Errorf("no recipients for %s", p)
+	}
+
+	name := path.Base(p)
+
+	// Attachments grow by a third once they're encoded so anything bigger
+	// than that can't fit, unless it's going to be split there's no point
+	// reading the rest of it
+	if d.maxSize > 0 && d.configuration.Oversize != "split" {
+		data = io.LimitReader(data, d.maxSize/4*3+1)
+	}
+	content, err := ioutil.ReadAll(data)
+	if err != nil {
+		return 0, err
+	}
+	if d.maxSize > 0 && d.configuration.Oversize != "split" && int64(len(content)) > d.maxSize/4*3 {
+		return 0, d.tooBig(name)
+	}
+
+	msgs, err := d.messages(folder, to, name, content)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range msgs {
+		if err := d.relay.Send(d.configuration.From, to, msg); err != nil {
+			return 0, fmt.Errorf("sending %s: %w", name, err)
+		}
+	}
+
+	d.sent.Remember(p, int64(len(content)))
+
+	return int64(len(content)), nil
+}
+
+// messages builds the emails to send a file in, there's only more than one
+// if it's split to keep each of them under max_size
+func (d *Driver) messages(folder string, to []string, name string, content []byte) ([][]byte, error) {
+	msg, err := d.message(folder, to, name, content, 1, 1)
+	if err != nil || d.fits(msg) {
+		return [][]byte{msg}, err
+	}
+
+	tooBig := d.tooBig(name)
+	if d.configuration.Oversize != "split" || !strings.EqualFold(path.Ext(name), ".pdf") {
+		return nil, tooBig
+	}
+
+	parts, err := d.split(folder, to, name, content)
+	if err != nil {
+		return nil, fmt.Errorf("%w and can't be split: %v", tooBig, err)
+	}
+
+	msgs := make([][]byte, len(parts))
+	for i, part := range parts {
+		if msgs[i], err = d.message(folder, to, name, part, i+1, len(parts)); err != nil {
+			return nil, err
+		}
+		if !d.fits(msgs[i]) {
+			return nil, fmt.Errorf("%w and part %d of %d is still too big", tooBig, i+1, len(parts))
+		}
+	}
+
+	return msgs, nil
+}
+
+func (d *Driver) tooBig(name string) error {
+	return fmt.Errorf("%s makes an email larger than the %d MiB limit", name, d.configuration.MaxSize)
+}
+
+func (d *Driver) fits(msg []byte) bool {
+	return d.maxSize <= 0 || int64(len(msg)) <= d.maxSize
+}
+
+// encodedSize is how big content of size n becomes once it's base64 encoded
+// into lines the way message does it
+func encodedSize(n int64) int64 {
+	encoded := int64(base64.StdEncoding.EncodedLen(int(n)))
+	lines := (encoded + 75) / 76
+	if lines == 0 {
+		lines = 1
+	}
+	return encoded + 2*lines
+}
+
+// split breaks a pdf into as few as possible that each fit in an email by
+// adding pages until the next one would make the email too big. Pages are
+// added to the part as it's being built so its size is known as it grows,
+// a part is only built again without the page that made it too big
+func (d *Driver) split(folder string, to []string, name string, content []byte) ([][]byte, error) {
+	r, err := pdf.NewReader(content)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := r.Pages()
+	if err != nil {
+		return nil, err
+	}
+
+	// The subject and attachment name can change with the number of parts,
+	// sizing them as if there were plenty leaves room for that
+	const plenty = 999
+	empty, err := d.message(folder, to, name, nil, plenty, plenty)
+	if err != nil {
+		return nil, err
+	}
+	fits := func(w *pdf.Writer) bool {
+		return d.maxSize <= 0 || int64(len(empty))-encodedSize(0)+encodedSize(w.Size()) <= d.maxSize
+	}
+
+	var parts [][]byte
+	var current []*pdf.Page
+	buf := new(bytes.Buffer)
+	w := pdf.NewWriter(buf)
+	for i, page := range pages {
+		if err := w.CopyPage(page); err != nil {
+			return nil, err
+		}
+		if fits(w) {
+			current = append(current, page)
+			continue
+		}
+		if len(current) == 0 {
+			return nil, fmt.Errorf("page %d is too big by itself", i+1)
+		}
+
+		part, err := build(current)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+
+		buf = new(bytes.Buffer)
+		w = pdf.NewWriter(buf)
+		if err := w.CopyPage(page); err != nil {
+			return nil, err
+		}
+		if !fits(w) {
+			return nil, fmt.Errorf("page %d is too big by itself", i+1)
+		}
+		current = []*pdf.Page{page}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	parts = append(parts, buf.Bytes())
+
+	return parts, nil
+}
+
+// build copies pages whole into a new pdf, text layers and all
+func build(pages []*pdf.Page) ([]byte, error) {
+	var buf bytes.Buffer
+	w := pdf.NewWriter(&buf)
+	for _, page := range pages {
+		if err := w.CopyPage(page); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// message builds an email with the file attached
+func (d *Driver) message(folder string, to []string, name string, content []byte, part, parts int) ([]byte, error) {
+	attachment := name
+	if parts > 1 {
+		ext := path.Ext(name)
+		attachment = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), part, ext)
+	}
+
+	data := struct {
+		Name      string
+		Recipient string
+		Part      int
+		Parts     int
+	}{name, folder, part, parts}
+
+	var subject, body bytes.Buffer
+	if err := d.subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("failure while templating subject: %w", err)
+	}
+	if err := d.body.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("failure while templating body: %w", err)
+	}
+
+	var msg bytes.Buffer
+	mw := multipart.NewWriter(&msg)
+
+	header := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		d.configuration.From, strings.Join(to, ", "), mime.QEncoding.Encode("utf-8", subject.String()), time.Now().Format(time.RFC1123Z), mw.Boundary())
+
+	w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	w.Write(body.Bytes())
+
+	contentType, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(attachment)))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": attachment})},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Lines in an email can't be longer than 998 characters
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 76 {
+		io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(header), msg.Bytes()...), nil
+}
+
+// Rename only applies to the record of what was sent, the email is gone
+func (d *Driver) Rename(from, to string) error {
+	return d.sent.Rename(from, to)
+}
+
+func (d *Driver) DeleteFile(p string) error {
+	return errors.New("Permission Denied, emails can't be unsent")
+}
+
+func NewDriver(fn func(v interface{}) error) (d *Driver, err error) {
+	d = &Driver{sent: sent.New()}
+	if err = fn(&d.configuration); err != nil {
+		return nil, err
+	}
+
+	if d.configuration.From == "" {
+		return nil, errors.New("Configuration for smtp is invalid, required from")
+	}
+
+	if len(d.configuration.To) == 0 && len(d.configuration.Recipients) == 0 {
+		return nil, errors.New("Configuration for smtp is invalid, required to or recipients")
+	}
+
+	for name, to := range d.configuration.Recipients {
+		if name == "" || strings.Contains(name, "/") || len(to) == 0 {
+			return nil, fmt.Errorf("Configuration for smtp is invalid, recipient %q needs a name without slashes and an address", name)
+		}
+	}
+
+	if d.relay, err = NewRelay(d.configuration.RelayConfig); err != nil {
+		return nil, fmt.Errorf("Configuration for smtp is invalid, %w", err)
+	}
+
+	switch d.configuration.Oversize {
+	case "", "reject", "split":
+	default:
+		return nil, fmt.Errorf("Configuration for smtp is invalid, unknown oversize %q, expected reject or split", d.configuration.Oversize)
+	}
+	d.maxSize = d.configuration.MaxSize * 1024 * 1024
+
+	if d.configuration.Subject == "" {
+		d.configuration.Subject = defaultSubject
+	}
+	if d.subject, err = template.New("subject").Parse(d.configuration.Subject); err != nil {
+		return nil, fmt.Errorf("failure while parsing subject: %w", err)
+	}
+
+	if d.configuration.Body == "" {
+		d.configuration.Body = defaultBody
+	}
+	if d.body, err = template.New("body").Parse(d.configuration.Body); err != nil {
+		return nil, fmt.Errorf("failure while parsing body: %w", err)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,296 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/freman/scantp/driver/pdf"
+)
+
+// fakeRelay accepts every message it's sent
+type fakeRelay struct {
+	l net.Listener
+
+	mu       sync.Mutex
+	messages []string
+}
+
+func newFakeRelay(t *testing.T) *fakeRelay {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRelay{l: l}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+
+	return r
+}
+
+func (r *fakeRelay) port() int {
+	return r.l.Addr().(*net.TCPAddr).Port
+}
+
+func (r *fakeRelay) serve(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	reply := func(line string) {
+		rw.WriteString(line + "\r\n")
+		rw.Flush()
+	}
+
+	reply("220 fake")
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+		case "EHLO", "HELO", "MAIL", "RCPT":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				line, err := rw.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(strings.TrimPrefix(line, "."))
+			}
+			r.mu.Lock()
+			r.messages = append(r.messages, msg.String())
+			r.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func newDriver(t *testing.T, relay *fakeRelay, config string) *Driver {
+	t.Helper()
+
+	var prim toml.Primitive
+	md, err := toml.Decode("host = \"127.0.0.1\"\nport = "+strconv.Itoa(relay.port())+"\nfrom = \"scanner@example.com\"\n"+config, &prim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDriver(func(v interface{}) error {
+		return md.PrimitiveDecode(prim, v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// noise makes a pdf with pages that won't compress, each about size bytes
+func noise(t *testing.T, pages, size int) []byte {
+	t.Helper()
+
+	rnd := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	w := pdf.NewWriter(&buf)
+	for i := 0; i < pages; i++ {
+		img := image.NewGray(image.Rect(0, 0, size/10, 10))
+		for j := range img.Pix {
+			img.Pix[j] = uint8(rnd.Intn(256))
+		}
+		img.Set(0, 0, color.Gray{Y: uint8(i)})
+		enc, err := pdf.Encode(img, 300)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddPage(enc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRecipients(t *testing.T) {
+	relay := newFakeRelay(t)
+	d := newDriver(t, relay, `to = ["office@example.com"]
+recipients = { accounts = ["accounts@example.com", "boss@example.com"] }`)
+
+	if _, err := d.PutFile("/accounts/invoice.pdf", strings.NewReader("%PDF"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PutFile("/scan.pdf", strings.NewReader("%PDF"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PutFile("/nobody/scan.pdf", strings.NewReader("%PDF"), false); err == nil {
+		t.Error("sent a file to a folder without recipients")
+	}
+
+	if len(relay.messages) != 2 {
+		t.Fatalf("relay got %d messages", len(relay.messages))
+	}
+	if msg := relay.messages[0]; !strings.Contains(msg, "To: accounts@example.com, boss@example.com\r\n") || !strings.Contains(msg, "Subject: Scan: invoice.pdf\r\n") {
+		t.Errorf("unexpected message\n%s", msg)
+	}
+	if msg := relay.messages[1]; !strings.Contains(msg, "To: office@example.com\r\n") {
+		t.Errorf("unexpected message\n%s", msg)
+	}
+
+	if info, err := d.Stat("/accounts"); err != nil || !info.IsDir() {
+		t.Errorf("stat of the recipient gave %v, %v", info, err)
+	}
+	if info, err := d.Stat("/accounts/invoice.pdf"); err != nil || info.Size() != 4 {
+		t.Errorf("stat of the sent file gave %v, %v", info, err)
+	}
+}
+
+// The limit is on the email, a file that's under it can still make an email
+// that isn't once it's encoded
+func TestMaxSizeCountsEncoding(t *testing.T) {
+	relay := newFakeRelay(t)
+	d := newDriver(t, relay, `to = ["office@example.com"]`)
+	d.maxSize = 100 * 1024
+
+	content := bytes.Repeat([]byte("x"), 90*1024)
+	if _, err := d.PutFile("/scan.bin", bytes.NewReader(content), false); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if len(relay.messages) != 0 {
+		t.Errorf("relay got %d messages", len(relay.messages))
+	}
+
+	if _, err := d.PutFile("/scan.bin", bytes.NewReader(content[:70*1024]), false); err != nil {
+		t.Error(err)
+	}
+}
+
+// endless is an upload that never ends
+type endless struct{ read int64 }
+
+func (e *endless) Read(p []byte) (int, error) {
+	e.read += int64(len(p))
+	return len(p), nil
+}
+
+// Uploads that can't fit are turned away without reading all of them
+func TestMaxSizeStopsReading(t *testing.T) {
+	relay := newFakeRelay(t)
+	d := newDriver(t, relay, `to = ["office@example.com"]`)
+	d.maxSize = 100 * 1024
+
+	data := &endless{}
+	if _, err := d.PutFile("/scan.bin", data, false); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if data.read > d.maxSize {
+		t.Errorf("read %d bytes", data.read)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	relay := newFakeRelay(t)
+	d := newDriver(t, relay, `to = ["office@example.com"]
+oversize = "split"`)
+	d.maxSize = 100 * 1024
+
+	// Pages of 20KiB are about 27KiB encoded so three fit in each email
+	content := noise(t, 10, 20*1024)
+	if _, err := d.PutFile("/scan.pdf", bytes.NewReader(content), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(relay.messages) != 4 {
+		t.Fatalf("split into %d emails, expected 4", len(relay.messages))
+	}
+	for i, msg := range relay.messages {
+		if int64(len(msg)) > d.maxSize {
+			t.Errorf("email %d is %d bytes", i+1, len(msg))
+		}
+		if want := "Subject: Scan: scan.pdf (" + strconv.Itoa(i+1) + " of 4)\r\n"; !strings.Contains(msg, want) {
+			t.Errorf("email %d doesn't have %q", i+1, want)
+		}
+		if want := `filename=scan-` + strconv.Itoa(i+1) + `.pdf`; !strings.Contains(msg, want) {
+			t.Errorf("email %d doesn't have %q", i+1, want)
+		}
+	}
+}
+
+func TestSplitPageTooBig(t *testing.T) {
+	relay := newFakeRelay(t)
+	d := newDriver(t, relay, `to = ["office@example.com"]
+oversize = "split"`)
+	d.maxSize = 50 * 1024
+
+	content := noise(t, 2, 40*1024)
+	if _, err := d.PutFile("/scan.pdf", bytes.NewReader(content), false); err == nil || !strings.Contains(err.Error(), "page 1 is too big") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if len(relay.messages) != 0 {
+		t.Errorf("relay got %d messages", len(relay.messages))
+	}
+}
+
+func TestSplitKeepsText(t *testing.T) {
+	relay := newFakeRelay(t)
+	d := newDriver(t, relay, `to = ["office@example.com"]
+oversize = "split"`)
+	d.maxSize = 50 * 1024
+
+	// Pages with nothing but a text layer, padded out so two fit in each
+	rnd := rand.New(rand.NewSource(1))
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	b.WriteString("2 0 obj\n<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R 6 0 R] /Count 4 /MediaBox [0 0 612 792] >>\nendobj\n")
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Type /Page /Parent 2 0 R /Contents %d 0 R >>\nendobj\n", i+3, i+7)
+	}
+	for i := 0; i < 4; i++ {
+		padding := make([]byte, 8*1024)
+		rnd.Read(padding)
+		content := fmt.Sprintf("BT (page %d) Tj ET\n%% %x", i+1, padding)
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", i+7, len(content), content)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+
+	parts, err := d.split("", []string{"office@example.com"}, "scan.pdf", []byte(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("split into %d parts, expected 2", len(parts))
+	}
+	for i, part := range parts {
+		for _, page := range []int{i*2 + 1, i*2 + 2} {
+			if want := fmt.Sprintf("(page %d)", page); !bytes.Contains(part, []byte(want)) {
+				t.Errorf("part %d lost the text of page %d", i+1, page)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// RelayConfig is how to reach an SMTP relay
+type RelayConfig struct {
+	Host     string `toml:"host"`
+	Port     int    `toml:"port"`
+	TLS      string `toml:"tls"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	Timeout  string `toml:"timeout"`
+}
+
+// Relay sends mail through an SMTP relay, connecting for each message
+type Relay struct {
+	addr     string
+	host     string
+	auth     smtp.Auth
+	implicit bool
+	timeout  time.Duration
+}
+
+// NewRelay checks the configuration, TLS is either "starttls" which
+// upgrades whenever the relay offers it or "implicit"
+func NewRelay(config RelayConfig) (*Relay, error) {
+	if config.Host == "" {
+		return nil, errors.New("host is required")
+	}
+
+	r := &Relay{host: config.Host, timeout: defaultTimeout}
+
+	switch config.TLS {
+	case "", "starttls":
+		if config.Port == 0 {
+			config.Port = 587
+		}
+	case "implicit":
+		r.implicit = true
+		if config.Port == 0 {
+			config.Port = 465
+		}
+	default:
+		return nil, fmt.Errorf("unknown tls %q, expected starttls or implicit", config.TLS)
+	}
+	r.addr = net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+
+	if config.Username != "" {
+		r.auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+
+	if config.Timeout != "" {
+		var err error
+		if r.timeout, err = time.ParseDuration(config.Timeout); err != nil {
+			return nil, fmt.Errorf("failure while parsing timeout: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// Send is smtp.SendMail with a timeout and implicit tls, the timeout covers
+// the whole conversation
+func (r *Relay) Send(from string, to []string, msg []byte) error {
+	dialer := &net.Dialer{Timeout: r.timeout}
+
+	var conn net.Conn
+	var err error
+	if r.implicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", r.addr, &tls.Config{ServerName: r.host})
+	} else {
+		conn, err = dialer.Dial("tcp", r.addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(r.timeout))
+
+	c, err := smtp.NewClient(conn, r.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if !r.implicit {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: r.host}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.auth != nil {
+		if err := c.Auth(r.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -3,6 +3,7 @@ package filesystem
 import (
 	"errors"
 	"os"
+	"path/filepath"
 
 	"goftp.io/server"
 )
@@ -41,3 +42,39 @@ func NewDriver(fn func(v interface{}) error) (d *Driver, err error) {
 	return d, nil
 
 }
+
+// Stat looks the file up under the root itself, server.FileDriver hands the
+// unrooted path to perm so everything but the root looked like it didn't
+// exist
+func (d *Driver) Stat(path string) (server.FileInfo, error) {
+	realPath := filepath.Join(d.configuration.RootPath, filepath.FromSlash(path))
+	info, err := os.Lstat(realPath)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &perm{}
+	owner, err := p.GetOwner(realPath)
+	if err != nil {
+		return nil, err
+	}
+	group, err := p.GetGroup(realPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{FileInfo: info, owner: owner, group: group}, nil
+}
+
+type fileInfo struct {
+	os.FileInfo
+	owner, group string
+}
+
+func (f *fileInfo) Owner() string {
+	return f.owner
+}
+
+func (f *fileInfo) Group() string {
+	return f.group
+}
@@ -0,0 +1,50 @@
+package filesystem
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStat(t *testing.T) {
+	root, err := ioutil.TempDir("", "scantp-fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.Mkdir(filepath.Join(root, "2020"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "2020", "scan.pdf"), []byte("scan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDriver(func(v interface{}) error {
+		v.(*struct {
+			RootPath string `toml:"root"`
+		}).RootPath = root
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := d.Stat("/2020/scan.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.IsDir() || info.Size() != 4 || info.Name() != "scan.pdf" {
+		t.Errorf("got %s, %d bytes, dir %v", info.Name(), info.Size(), info.IsDir())
+	}
+
+	if info, err := d.Stat("/2020"); err != nil || !info.IsDir() {
+		t.Errorf("directory: %v", err)
+	}
+
+	if _, err := d.Stat("/2020/missing.pdf"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file gave %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"time"
+
+	"github.com/freman/scantp/driver/sent"
+	ftpc "github.com/jlaffaye/ftp"
+	"goftp.io/server"
+)
+
+const (
+	defaultTimeout     = 30 * time.Second
+	defaultConnections = 2
+)
+
+type Driver struct {
+	options []ftpc.DialOption
+	idle    chan *ftpc.ServerConn
+	// slots holds one entry for every connection in use, so no more than
+	// the configured number are ever open at once
+	slots chan struct{}
+
+	configuration struct {
+		Address     string `toml:"address"`
+		Username    string `toml:"username"`
+		Password    string `toml:"password"`
+		Root        string `toml:"root"`
+		TLS         string `toml:"tls"`
+		Insecure    bool   `toml:"insecure"`
+		Timeout     string `toml:"timeout"`
+		Connections int    `toml:"connections"`
+	}
+}
+
+// remotePath maps a path within the driver to a path on the upstream server
+func (d *Driver) remotePath(p string) string {
+	return path.Join(d.configuration.Root, p)
+}
+
+// acquire hands out an idle connection if there is one that is still alive,
+// or logs in a new one
+func (d *Driver) acquire() (*ftpc.ServerConn, error) {
+	for {
+		select {
+		case conn := <-d.idle:
+			if err := conn.NoOp(); err != nil {
+				conn.Quit()
+				continue
+			}
+			return conn, nil
+		default:
+			return d.dial()
+		}
+	}
+}
+
+// release returns a connection to the idle pool, closing it if the pool is
+// already full
+func (d *Driver) release(conn *ftpc.ServerConn) {
+	select {
+	case d.idle <- conn:
+	default:
+		conn.Quit()
+	}
+}
+
+func (d *Driver) dial() (*ftpc.ServerConn, error) {
+	conn, err := ftpc.Dial(d.configuration.Address, d.options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Login(d.configuration.Username, d.configuration.Password); err != nil {
+		conn.Quit()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// withConn runs fn against a pooled connection, waiting for one to free up if
+// they're all in use. Connections are only reused if they didn't fail below
+// the ftp protocol level
+func (d *Driver) withConn(fn func(*ftpc.ServerConn) error) error {
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+
+	conn, err := d.acquire()
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s: %w", d.configuration.Address, err)
+	}
+
+	err = fn(conn)
+	var protoErr *textproto.Error
+	if err == nil || errors.As(err, &protoErr) || errors.Is(err, os.ErrNotExist) {
+		d.release(conn)
+	} else {
+		conn.Quit()
+	}
+
+	return err
+}
+
+func (d *Driver) Stat(p string) (server.FileInfo, error) {
+	if p == "/" || p == "" {
+		return &fileInfo{&ftpc.Entry{Name: "/", Type: ftpc.EntryTypeFolder}}, nil
+	}
+
+	var info server.FileInfo
+	err := d.withConn(func(conn *ftpc.ServerConn) error {
+		entries, err := conn.List(path.Dir(d.remotePath(p)))
+		if err != nil {
+			// The parent directory doesn't exist either
+			var protoErr *textproto.Error
+			if errors.As(err, &protoErr) && protoErr.Code == ftpc.StatusFileUnavailable {
+				return fmt.Errorf("%s: %w", p, os.ErrNotExist)
+			}
+			return err
+		}
+
+		name := path.Base(p)
+		for _, entry := range entries {
+			if entry.Name == name {
+				info = &fileInfo{entry}
+				return nil
+			}
+		}
+
+		return fmt.Errorf("%s: %w", p, os.ErrNotExist)
+	})
+
+	return info, err
+}
+
+func (d *Driver) ListDir(p string, fn func(server.FileInfo) error) error {
+	var entries []*ftpc.Entry
+	err := d.withConn(func(conn *ftpc.ServerConn) (err error) {
+		entries, err = conn.List(d.remotePath(p))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		if err := fn(&fileInfo{entry}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Driver) MakeDir(p string) error {
+	return d.withConn(func(conn *ftpc.ServerConn) error {
+		return conn.MakeDir(d.remotePath(p))
+	})
+}
+
+func (d *Driver) PutFile(p string, stream io.Reader, appendData bool) (int64, error) {
+	counter := &sent.CountingReader{Reader: stream}
+	err := d.withConn(func(conn *ftpc.ServerConn) error {
+		if appendData {
+			return conn.Append(d.remotePath(p), counter)
+		}
+		return conn.Stor(d.remotePath(p), counter)
+	})
+
+	return counter.N, err
+}
+
+func (d *Driver) Rename(from, to string) error {
+	return d.withConn(func(conn *ftpc.ServerConn) error {
+		return conn.Rename(d.remotePath(from), d.remotePath(to))
+	})
+}
+
+func (d *Driver) DeleteFile(p string) error {
+	return d.withConn(func(conn *ftpc.ServerConn) error {
+		return conn.Delete(d.remotePath(p))
+	})
+}
+
+func NewDriver(fn func(v interface{}) error) (d *Driver, err error) {
+	d = &Driver{}
+	d.configuration.Root = "/"
+	d.configuration.Connections = defaultConnections
+	if err = fn(&d.configuration); err != nil {
+		return nil, err
+	}
+
+	if d.configuration.Address == "" || d.configuration.Username == "" {
+		return nil, errors.New("Configuration for ftp is invalid, required address and username")
+	}
+
+	host, _, err := net.SplitHostPort(d.configuration.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failure while parsing ftp address: %w", err)
+	}
+
+	timeout := defaultTimeout
+	if d.configuration.Timeout != "" {
+		if timeout, err = time.ParseDuration(d.configuration.Timeout); err != nil {
+			return nil, fmt.Errorf("failure while parsing ftp timeout: %w", err)
+		}
+	}
+
+	d.options = []ftpc.DialOption{ftpc.DialWithTimeout(timeout)}
+
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: d.configuration.Insecure,
+	}
+
+	switch d.configuration.TLS {
+	case "", "none":
+	case "explicit":
+		d.options = append(d.options, ftpc.DialWithExplicitTLS(tlsConfig))
+	case "implicit":
+		d.options = append(d.options, ftpc.DialWithTLS(tlsConfig))
+	default:
+		return nil, fmt.Errorf("unknown tls mode %q, expected none, explicit or implicit", d.configuration.TLS)
+	}
+
+	if d.configuration.Connections < 1 {
+		d.configuration.Connections = 1
+	}
+	d.idle = make(chan *ftpc.ServerConn, d.configuration.Connections)
+	d.slots = make(chan struct{}, d.configuration.Connections)
+
+	return d, nil
+}
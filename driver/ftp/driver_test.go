@@ -0,0 +1,228 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	ftpc "github.com/jlaffaye/ftp"
+	"goftp.io/server"
+)
+
+func configure(config string) func(v interface{}) error {
+	return func(v interface{}) error {
+		_, err := toml.Decode(config, v)
+		return err
+	}
+}
+
+// upstream starts an in process ftp server serving a temporary directory,
+// returning the driver's configuration for it and the directory
+func upstream(t *testing.T) (string, string) {
+	t.Helper()
+
+	root, err := ioutil.TempDir("", "scantp-ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.NewServer(&server.ServerOpts{
+		Factory:  &server.FileDriverFactory{RootPath: root, Perm: server.NewSimplePerm("scanner", "scanner")},
+		Auth:     &server.SimpleAuth{Name: "scanner", Password: "scanme"},
+		Hostname: "127.0.0.1",
+		Port:     l.Addr().(*net.TCPAddr).Port,
+		Logger:   &server.DiscardLogger{},
+	})
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Shutdown() })
+
+	return fmt.Sprintf("address = %q\nusername = \"scanner\"\npassword = \"scanme\"\ntimeout = \"5s\"", l.Addr().String()), root
+}
+
+func newDriver(t *testing.T, config string) *Driver {
+	t.Helper()
+
+	d, err := NewDriver(configure(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for {
+			select {
+			case conn := <-d.idle:
+				conn.Quit()
+			default:
+				return
+			}
+		}
+	})
+	return d
+}
+
+func list(t *testing.T, d *Driver, p string) string {
+	t.Helper()
+
+	var names []string
+	if err := d.ListDir(p, func(info server.FileInfo) error {
+		names = append(names, info.Name())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestDriver(t *testing.T) {
+	config, root := upstream(t)
+	d := newDriver(t, config+"\nroot = \"/incoming\"")
+
+	if err := d.MakeDir("/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.MakeDir("/2020"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.PutFile("/2020/scan.pdf", strings.NewReader("%PDF-1.4"), false); err != nil || n != 8 {
+		t.Fatalf("put %d bytes: %v", n, err)
+	}
+	if n, err := d.PutFile("/2020/scan.pdf", strings.NewReader(" more"), true); err != nil || n != 5 {
+		t.Fatalf("appended %d bytes: %v", n, err)
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(root, "incoming", "2020", "scan.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "%PDF-1.4 more" {
+		t.Errorf("wrote %q", buf)
+	}
+
+	info, err := d.Stat("/2020/scan.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "scan.pdf" || info.Size() != 13 || info.IsDir() {
+		t.Errorf("stat gave %s, %d bytes, directory %v", info.Name(), info.Size(), info.IsDir())
+	}
+	if info, err := d.Stat("/2020"); err != nil || !info.IsDir() {
+		t.Errorf("directory stat: %v", err)
+	}
+	if got := list(t, d, "/2020"); got != "scan.pdf" {
+		t.Errorf("listed %s", got)
+	}
+
+	if err := d.Rename("/2020/scan.pdf", "/2020/renamed.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteFile("/2020/renamed.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(t, d, "/2020"); got != "" {
+		t.Errorf("after delete listed %s", got)
+	}
+}
+
+// Conflict policies rely on missing files looking missing, not like the
+// server is down
+func TestStatMissing(t *testing.T) {
+	config, _ := upstream(t)
+	d := newDriver(t, config)
+
+	for _, p := range []string{"/missing.pdf", "/missing/scan.pdf"} {
+		if _, err := d.Stat(p); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+}
+
+// Connections are kept for the next request rather than logging in again
+func TestPool(t *testing.T) {
+	config, _ := upstream(t)
+	d := newDriver(t, config+"\nconnections = 1")
+
+	if _, err := d.PutFile("/scan.pdf", strings.NewReader("%PDF"), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.idle) != 1 {
+		t.Fatalf("%d idle connections", len(d.idle))
+	}
+	conn := <-d.idle
+	d.release(conn)
+
+	if _, err := d.Stat("/scan.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-d.idle; got != conn {
+		t.Error("logged in again instead of reusing the connection")
+	}
+}
+
+// No more connections than configured are opened, the rest wait their turn
+func TestPoolLimit(t *testing.T) {
+	config, _ := upstream(t)
+	d := newDriver(t, config+"\nconnections = 1")
+
+	held := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		d.withConn(func(*ftpc.ServerConn) error {
+			close(held)
+			<-done
+			return nil
+		})
+	}()
+	<-held
+
+	statted := make(chan error, 1)
+	go func() {
+		_, err := d.Stat("/scan.pdf")
+		statted <- err
+	}()
+
+	select {
+	case <-statted:
+		t.Fatal("opened a second connection")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(done)
+	select {
+	case err := <-statted:
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("never got the connection")
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	tests := map[string]string{
+		"no address":  `username = "scanner"`,
+		"no username": `address = "localhost:21"`,
+		"no port":     "address = \"localhost\"\nusername = \"scanner\"",
+		"bad tls":     "address = \"localhost:21\"\nusername = \"scanner\"\ntls = \"sometimes\"",
+		"bad timeout": "address = \"localhost:21\"\nusername = \"scanner\"\ntimeout = \"soon\"",
+	}
+
+	for name, config := range tests {
+		if _, err := NewDriver(configure(config)); err == nil {
+			t.Errorf("%s: configuration was accepted", name)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package ftp
+
+import (
+	"os"
+	"time"
+
+	ftpc "github.com/jlaffaye/ftp"
+)
+
+type fileInfo struct {
+	*ftpc.Entry
+}
+
+func (f fileInfo) Name() string {
+	return f.Entry.Name
+}
+func (f fileInfo) Size() int64 {
+	return int64(f.Entry.Size)
+}
+func (f fileInfo) Mode() os.FileMode {
+	if f.IsDir() {
+		return os.ModeDir | 0777
+	}
+	return 0666
+}
+func (f fileInfo) ModTime() time.Time {
+	return f.Entry.Time
+}
+func (f fileInfo) IsDir() bool {
+	return f.Entry.Type == ftpc.EntryTypeFolder
+}
+func (f fileInfo) Sys() interface{} {
+	return nil
+}
+
+func (f fileInfo) Owner() string {
+	return "nobody"
+}
+
+func (f fileInfo) Group() string {
+	return "nobody"
+}
@@ -0,0 +1,281 @@
+// Package httppost streams uploads to an HTTP endpoint, either as a
+// multipart form or as the raw request body
+package httppost
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/freman/scantp/driver/retry"
+	"github.com/freman/scantp/driver/sent"
+	"goftp.io/server"
+)
+
+const (
+	defaultTimeout = 10 * time.Minute
+)
+
+type Driver struct {
+	url           *template.Template
+	fields        map[string]*template.Template
+	authorization string
+	httpClient    *http.Client
+
+	sent *sent.Files
+
+	configuration struct {
+		URL      string            `toml:"url"`
+		Method   string            `toml:"method"`
+		Body     string            `toml:"body"`
+		Field    string            `toml:"field"`
+		Fields   map[string]string `toml:"fields"`
+		Headers  map[string]string `toml:"headers"`
+		Username string            `toml:"username"`
+		Password string            `toml:"password"`
+		Token    string            `toml:"token"`
+		Timeout  string            `toml:"timeout"`
+	}
+}
+
+// templateData is what the url and fields can refer to
+type templateData struct {
+	Path string
+	Name string
+	Dir  string
+}
+
+// escaped is the data with everything but letters, digits, "-", "_", "."
+// and "~" percent encoded, the slashes between folders are kept. That's
+// safe anywhere in a url, path or query string
+func (t templateData) escaped() templateData {
+	return templateData{Path: escape(t.Path), Name: escape(t.Name), Dir: escape(t.Dir)}
+}
+
+func escape(s string) string {
+	parts := strings.Split(s, "/")
+	for i, part := range parts {
+		parts[i] = strings.Replace(url.QueryEscape(part), "+", "%20", -1)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (d *Driver) Stat(p string) (server.FileInfo, error) {
+	return d.sent.Stat(p)
+}
+
+// ListDir is always empty, nothing is kept once it's sent
+func (d *Driver) ListDir(p string, fn func(server.FileInfo) error) error {
+	return nil
+}
+
+// MakeDir succeeds so scanners and templates can use folders, the folder is
+// available to the url as {{.Dir}}
+func (d *Driver) MakeDir(p string) error {
+	d.sent.AddDir(p)
+	return nil
+}
+
+func (d *Driver) PutFile(p string, stream io.Reader, appendData bool) (int64, error) {
+	if appendData {
+		return 0, errors.New("Appending to files is not supported")
+	}
+
+	p = path.Clean("/" + p)
+	data := templateData{Path: p, Name: path.Base(p), Dir: path.Dir(p)}
+
+	var target bytes.Buffer
+	if err := d.url.Execute(&target, data.escaped()); err != nil {
+		return 0, fmt.Errorf("failure while templating url: %w", err)
+	}
+
+	var body io.Reader
+	var contentType string
+	counter := &sent.CountingReader{Reader: stream}
+	copied := make(chan error, 1)
+
+	if d.configuration.Body == "raw" {
+		body = counter
+		if contentType = mime.TypeByExtension(path.Ext(p)); contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		copied <- nil
+	} else {
+		fields := make(map[string]string, len(d.fields))
+		for name, tmpl := range d.fields {
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, data); err != nil {
+				return 0, fmt.Errorf("failure while templating field %s: %w", name, err)
+			}
+			fields[name] = buf.String()
+		}
+
+		// Stream the multipart body through a pipe so the upload is never
+		// held in memory
+		r, pipe := io.Pipe()
+		writer := multipart.NewWriter(pipe)
+		contentType = writer.FormDataContentType()
+		body = r
+		go func() {
+			var err error
+			for name, value := range fields {
+				if err = writer.WriteField(name, value); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				var part io.Writer
+				if part, err = writer.CreateFormFile(d.configuration.Field, data.Name); err == nil {
+					_, err = io.Copy(part, counter)
+				}
+			}
+			if err == nil {
+				err = writer.Close()
+			}
+			pipe.CloseWithError(err)
+			copied <- err
+		}()
+		defer r.Close()
+	}
+
+	req, err := http.NewRequestWithContext(context.TODO(), d.configuration.Method, target.String(), body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range d.configuration.Headers {
+		req.Header.Set(k, v)
+	}
+	if d.authorization != "" {
+		req.Header.Set("Authorization", d.authorization)
+	}
+
+	err = d.doRequest(req)
+	if err != nil {
+		return 0, err
+	}
+	if err := <-copied; err != nil {
+		return 0, err
+	}
+
+	d.sent.Remember(p, counter.N)
+
+	return counter.N, nil
+}
+
+// doRequest treats anything but a 2xx as a failure, a little of the body is
+// kept in the error since endpoints usually say what they didn't like
+func (d *Driver) doRequest(r *http.Request) error {
+	resp, err := d.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	detail, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return &statusError{code: resp.StatusCode, status: resp.Status, body: strings.TrimSpace(string(detail))}
+}
+
+type statusError struct {
+	code   int
+	status string
+	body   string
+}
+
+func (e *statusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("http status error %s", e.status)
+	}
+	return fmt.Sprintf("http status error %s: %s", e.status, e.body)
+}
+
+// Is makes requests the server refused as wrong permanent failures, sending
+// them again won't change its mind
+func (e *statusError) Is(target error) bool {
+	return target == retry.ErrPermanent && retry.PermanentStatus(e.code)
+}
+
+func (d *Driver) Rename(from, to string) error {
+	return errors.New("Permission Denied, uploads can't be renamed once they're sent")
+}
+
+func (d *Driver) DeleteFile(p string) error {
+	return errors.New("Permission Denied, uploads can't be deleted once they're sent")
+}
+
+func NewDriver(fn func(v interface{}) error) (d *Driver, err error) {
+	d = &Driver{sent: sent.New()}
+	d.configuration.Method = http.MethodPost
+	d.configuration.Body = "multipart"
+	d.configuration.Field = "file"
+	if err = fn(&d.configuration); err != nil {
+		return nil, err
+	}
+
+	if d.configuration.URL == "" {
+		return nil, errors.New("Configuration for http is invalid, required url")
+	}
+
+	if d.url, err = template.New("url").Parse(d.configuration.URL); err != nil {
+		return nil, fmt.Errorf("failure while parsing url: %w", err)
+	}
+
+	// Check the url makes sense with something in it
+	var check bytes.Buffer
+	if err := d.url.Execute(&check, templateData{Path: "/scan.pdf", Name: "scan.pdf", Dir: "/"}); err != nil {
+		return nil, fmt.Errorf("failure while parsing url: %w", err)
+	}
+	if u, err := url.Parse(check.String()); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Configuration for http is invalid, url %q isn't absolute", d.configuration.URL)
+	}
+
+	switch d.configuration.Body {
+	case "multipart", "raw":
+	default:
+		return nil, fmt.Errorf("Configuration for http is invalid, unknown body %q, expected multipart or raw", d.configuration.Body)
+	}
+
+	d.fields = make(map[string]*template.Template, len(d.configuration.Fields))
+	for name, value := range d.configuration.Fields {
+		if d.fields[name], err = template.New(name).Parse(value); err != nil {
+			return nil, fmt.Errorf("failure while parsing field %s: %w", name, err)
+		}
+	}
+
+	if d.configuration.Token != "" && d.configuration.Username != "" {
+		return nil, errors.New("Configuration for http is invalid, use either username and password or token, not both")
+	}
+
+	if d.configuration.Token != "" {
+		d.authorization = "Bearer " + d.configuration.Token
+	} else if d.configuration.Username != "" {
+		d.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(d.configuration.Username+":"+d.configuration.Password))
+	}
+
+	timeout := defaultTimeout
+	if d.configuration.Timeout != "" {
+		if timeout, err = time.ParseDuration(d.configuration.Timeout); err != nil {
+			return nil, fmt.Errorf("failure while parsing timeout: %w", err)
+		}
+	}
+	d.httpClient = &http.Client{Timeout: timeout}
+
+	return d, nil
+}
@@ -0,0 +1,28 @@
+package driver
+
+import (
+	"net"
+
+	"goftp.io/server"
+)
+
+// ConnIniter is implemented by drivers that need the connection they're
+// serving. The ftp server has to call Init before the driver is used for
+// users to be kept to their own paths, stock goftp.io/server never does
+// which is why scantp is built against a patched one
+type ConnIniter interface {
+	Init(*server.Conn)
+}
+
+var _ ConnIniter = &MultipleDriver{}
+
+// patchedConn is what the patched server's connections have that stock
+// goftp.io/server's don't. Asserting it here means scantp won't build
+// against a server library that would never call Init, rather than
+// starting up and locking every user out of everything
+type patchedConn interface {
+	SessionID() string
+	RemoteAddr() net.Addr
+}
+
+var _ patchedConn = (*server.Conn)(nil)
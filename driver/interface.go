@@ -12,4 +12,13 @@ type Driver interface {
 	ListDir(string, func(server.FileInfo) error) error
 	MakeDir(string) error
 	PutFile(string, io.Reader, bool) (int64, error)
+	Rename(string, string) error
+	DeleteFile(string) error
+}
+
+// UserDriver is implemented by drivers that want to know which path and user
+// an upload came from, they're given it in place of PutFile. The user is
+// empty for files that weren't uploaded by anyone in particular
+type UserDriver interface {
+	PutFileAs(pathName, user, realPath string, data io.Reader, appendData bool) (int64, error)
 }
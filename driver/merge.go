@@ -0,0 +1,551 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freman/scantp/driver/pdf"
+	"github.com/freman/scantp/driver/retry"
+	"github.com/freman/scantp/metrics"
+	"github.com/sirupsen/logrus"
+	"goftp.io/server"
+
+	// Formats scanners save pages as
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/tiff"
+)
+
+const (
+	defaultMergeTimeout = 30 * time.Second
+	mergeDir            = "merge"
+)
+
+var defaultMergeMatch = []string{"*.jpg", "*.jpeg", "*.png", "*.tif", "*.tiff"}
+
+// mergeMode decides which images end up in the same document
+type mergeMode string
+
+const (
+	mergeSession mergeMode = "session"
+	mergePattern mergeMode = "pattern"
+)
+
+// errUnreadablePage is a page that will never go into a pdf however many
+// times it's tried
+var errUnreadablePage = errors.New("unreadable page")
+
+// merger holds on to images uploaded a page at a time and turns them into a
+// single pdf once no more pages have turned up for a while. Pages are kept in
+// the spool so they survive restarts
+type merger struct {
+	name    string
+	mode    mergeMode
+	match   []string
+	pattern *regexp.Regexp
+	timeout time.Duration
+	dpi     float64
+	dir     string
+	// boot tells this run's sessions apart from the last one's, session
+	// numbers start again on every launch and pages left over from before
+	// mustn't pick up someone else's
+	boot       string
+	maxBackoff time.Duration
+	maxAge     time.Duration
+	deliver    func(source, string, io.Reader) error
+	// failed is told about merged files that couldn't be delivered
+	failed func(source, string, error)
+
+	mu     sync.Mutex
+	groups map[string]*mergeGroup
+	// retrying are groups that failed to merge and are waiting to try again,
+	// they're kept apart since new pages with the same key start a new group
+	retrying map[*mergeGroup]bool
+	seq      uint64
+}
+
+type mergeGroup struct {
+	key      string
+	pages    []*mergePage
+	timer    *time.Timer
+	attempts int
+}
+
+// mergePage is an image waiting to be merged, it's persisted next to the
+// data as json like spooled jobs are
+type mergePage struct {
+	id       string
+	Key      string    `json:"key"`
+	Source   source    `json:"source"`
+	RealPath string    `json:"path"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+}
+
+func newMerger(name string, options pathOptions, maxBackoff, maxAge time.Duration, deliver func(source, string, io.Reader) error) (m *merger, err error) {
+	m = &merger{
+		name:       name,
+		mode:       mergeMode(options.Merge),
+		match:      options.MergeMatch,
+		timeout:    defaultMergeTimeout,
+		dpi:        options.MergeDPI,
+		boot:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		maxBackoff: maxBackoff,
+		maxAge:     maxAge,
+		deliver:    deliver,
+		groups:     make(map[string]*mergeGroup),
+		retrying:   make(map[*mergeGroup]bool),
+	}
+
+	switch m.mode {
+	case mergeSession:
+	case mergePattern:
+		if options.MergePattern == "" {
+			return nil, errors.New("merge_pattern is required when merging by pattern")
+		}
+		if m.pattern, err = regexp.Compile(options.MergePattern); err != nil {
+			return nil, fmt.Errorf("invalid merge_pattern: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown merge %q, expected session or pattern", options.Merge)
+	}
+
+	if len(m.match) == 0 {
+		m.match = defaultMergeMatch
+	}
+	for _, match := range m.match {
+		if _, err := path.Match(match, ""); err != nil {
+			return nil, fmt.Errorf("invalid merge_match %q: %w", match, err)
+		}
+	}
+
+	if options.MergeTimeout != "" {
+		if m.timeout, err = time.ParseDuration(options.MergeTimeout); err != nil {
+			return nil, fmt.Errorf("failure while parsing merge_timeout: %w", err)
+		}
+	}
+
+	if m.dpi == 0 {
+		m.dpi = pdf.DefaultDPI
+	}
+
+	if options.Spool == "" {
+		return nil, errors.New("merge needs a spool to keep pages in")
+	}
+	m.dir = filepath.Join(options.Spool, mergeDir)
+	if err := os.MkdirAll(filepath.Join(m.dir, spoolFailedDir), 0700); err != nil {
+		return nil, err
+	}
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *merger) log() *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{"path": m.name, "component": "merge"})
+}
+
+// key works out which document an image belongs to, or that it doesn't
+// belong to one at all. Names are matched in lower case
+func (m *merger) key(session, realPath string) (string, bool) {
+	name := strings.ToLower(path.Base(realPath))
+
+	var matched bool
+	for _, match := range m.match {
+		if matched, _ = path.Match(match, name); matched {
+			break
+		}
+	}
+	if !matched {
+		return "", false
+	}
+
+	if m.mode == mergeSession {
+		return m.boot + "/" + session, true
+	}
+
+	sub := m.pattern.FindStringSubmatch(path.Base(realPath))
+	switch len(sub) {
+	case 0:
+		return "", false
+	case 1:
+		return path.Dir(realPath) + "/" + sub[0], true
+	}
+	return path.Dir(realPath) + "/" + sub[1], true
+}
+
+func (m *merger) dataFile(id string) string {
+	return filepath.Join(m.dir, id+".data")
+}
+
+func (m *merger) pageFile(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
+
+// load picks up the pages left over from last time and gives them the full
+// timeout for more to turn up, data without a page file never finished
+// uploading so it's thrown away
+func (m *merger) load() error {
+	entries, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return err
+	}
+
+	pages := make(map[string]bool)
+	for _, entry := range entries {
+		if id := strings.TrimSuffix(entry.Name(), ".json"); id != entry.Name() {
+			pages[id] = true
+		}
+	}
+
+	var loaded []*mergePage
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		id := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if !pages[id] {
+			os.Remove(filepath.Join(m.dir, entry.Name()))
+			continue
+		}
+
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		buf, err := ioutil.ReadFile(m.pageFile(id))
+		if err != nil {
+			return err
+		}
+
+		page := &mergePage{id: id}
+		if err := json.Unmarshal(buf, page); err != nil {
+			return fmt.Errorf("unable to read merge page %s: %w", id, err)
+		}
+		loaded = append(loaded, page)
+	}
+
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].id < loaded[j].id
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, page := range loaded {
+		m.hold(page)
+	}
+
+	if len(loaded) > 0 {
+		m.log().Infof("resuming merge of %d pages into %d documents", len(loaded), len(m.groups))
+	}
+
+	return nil
+}
+
+// save writes the page file, the rename means it's either all there or not
+// there at all
+func (m *merger) save(page *mergePage) error {
+	buf, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+
+	tmp := m.pageFile(page.id) + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, m.pageFile(page.id))
+}
+
+func (m *merger) nextID() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	return fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), m.seq%1000000)
+}
+
+// add takes an image and holds on to it, returning false if it isn't one
+// that should be merged
+func (m *merger) add(session string, src source, realPath string, data io.Reader) (int64, bool, error) {
+	key, isa := m.key(session, realPath)
+	if !isa {
+		return 0, false, nil
+	}
+
+	page := &mergePage{
+		id:       m.nextID(),
+		Key:      key,
+		Source:   src,
+		RealPath: realPath,
+		Created:  time.Now(),
+	}
+
+	f, err := os.OpenFile(m.dataFile(page.id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, true, err
+	}
+
+	page.Size, err = io.Copy(f, data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = m.save(page)
+	}
+	if err != nil {
+		os.Remove(m.dataFile(page.id))
+		return 0, true, err
+	}
+
+	m.mu.Lock()
+	m.hold(page)
+	m.mu.Unlock()
+
+	return page.Size, true, nil
+}
+
+// hold adds a page to its group and restarts the group's timer, m.mu must
+// be held
+func (m *merger) hold(page *mergePage) {
+	group, exists := m.groups[page.Key]
+	if !exists {
+		group = &mergeGroup{key: page.Key}
+		group.timer = time.AfterFunc(m.timeout, func() { m.flush(group) })
+		m.groups[page.Key] = group
+	} else {
+		group.timer.Reset(m.timeout)
+	}
+
+	group.pages = append(group.pages, page)
+}
+
+// flush turns a group of images into a pdf and delivers it, trying again
+// later if it can't
+func (m *merger) flush(group *mergeGroup) {
+	m.mu.Lock()
+	if m.groups[group.key] == group {
+		delete(m.groups, group.key)
+	} else if !m.retrying[group] {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.retrying, group)
+
+	if len(group.pages) == 0 {
+		m.mu.Unlock()
+		return
+	}
+
+	if m.mode == mergePattern {
+		sort.SliceStable(group.pages, func(i, j int) bool {
+			return group.pages[i].RealPath < group.pages[j].RealPath
+		})
+	}
+	pages := append([]*mergePage(nil), group.pages...)
+	m.mu.Unlock()
+
+	first := pages[0].RealPath
+	realPath := strings.TrimSuffix(first, path.Ext(first)) + ".pdf"
+
+	// The document is made of several uploads so it isn't any one of them
+	src := pages[0].Source
+	src.upload = nil
+
+	err := m.merge(src, realPath, pages)
+	if err == nil {
+		m.log().WithFields(logrus.Fields{"file": realPath, "pages": len(pages)}).Info("delivered")
+		for _, page := range pages {
+			m.remove(page)
+		}
+		return
+	}
+
+	group.attempts++
+	if errors.Is(err, errUnreadablePage) || errors.Is(err, retry.ErrPermanent) || (m.maxAge > 0 && time.Since(pages[0].Created) >= m.maxAge) {
+		m.log().WithFields(logrus.Fields{"file": realPath, "attempts": group.attempts, "moved_to": path.Join(mergeDir, spoolFailedDir)}).WithError(err).Error("giving up on merge")
+		for _, page := range pages {
+			m.fail(page)
+		}
+		metrics.Failure(m.name, "merge")
+		if m.failed != nil {
+			m.failed(src, realPath, err)
+		}
+		return
+	}
+
+	backoff := spoolInitialBackoff
+	for i := 1; i < group.attempts && backoff < m.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > m.maxBackoff {
+		backoff = m.maxBackoff
+	}
+
+	m.log().WithFields(logrus.Fields{
+		"file":     realPath,
+		"attempts": group.attempts,
+		"retry_at": time.Now().Add(backoff).Format(time.RFC3339),
+	}).WithError(err).Warn("merge failed")
+
+	m.mu.Lock()
+	m.retrying[group] = true
+	group.timer = time.AfterFunc(backoff, func() { m.flush(group) })
+	m.mu.Unlock()
+}
+
+func (m *merger) remove(page *mergePage) {
+	os.Remove(m.pageFile(page.id))
+	os.Remove(m.dataFile(page.id))
+}
+
+// fail keeps the pages around for a human to look at
+func (m *merger) fail(page *mergePage) {
+	failed := filepath.Join(m.dir, spoolFailedDir)
+	os.Rename(m.dataFile(page.id), filepath.Join(failed, page.id+".data"))
+	os.Rename(m.pageFile(page.id), filepath.Join(failed, page.id+".json"))
+}
+
+func (m *merger) merge(src source, realPath string, pages []*mergePage) error {
+	f, err := ioutil.TempFile(m.dir, "*.pdf.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := pdf.NewWriter(f)
+	for _, page := range pages {
+		img, err := m.image(m.dataFile(page.id))
+		if err != nil {
+			return fmt.Errorf("%w %s: %v", errUnreadablePage, page.RealPath, err)
+		}
+
+		if err := w.AddPage(img); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return m.deliver(src, realPath, f)
+}
+
+// image loads a page, jpegs go into the pdf as they are and everything else
+// is recompressed losslessly
+func (m *merger) image(file string) (*pdf.Image, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if img, err := pdf.JPEG(data); err == nil {
+		return img, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return pdf.Encode(src, m.dpi)
+}
+
+// find looks for an image still waiting to be merged, m.mu must be held
+func (m *merger) find(match pending) (*mergeGroup, int) {
+	for _, group := range m.groups {
+		if i := group.find(match); i >= 0 {
+			return group, i
+		}
+	}
+	for group := range m.retrying {
+		if i := group.find(match); i >= 0 {
+			return group, i
+		}
+	}
+	return nil, -1
+}
+
+func (g *mergeGroup) find(match pending) int {
+	for i, page := range g.pages {
+		if match(page.Source, page.RealPath) {
+			return i
+		}
+	}
+	return -1
+}
+
+// stat reports on an image still waiting to be merged
+func (m *merger) stat(match pending) (server.FileInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, i := m.find(match)
+	if group == nil {
+		return nil, false
+	}
+
+	page := group.pages[i]
+	return &spooledFile{name: path.Base(page.RealPath), size: page.Size, modTime: page.Created}, true
+}
+
+// rename renames an image still waiting to be merged, it stays in the same
+// document
+func (m *merger) rename(match pending, to string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, i := m.find(match)
+	if group == nil {
+		return false
+	}
+
+	page := group.pages[i]
+	page.RealPath = to
+	if err := m.save(page); err != nil {
+		m.log().WithField("file", to).WithError(err).Error("unable to save state")
+	}
+	return true
+}
+
+// delete drops an image still waiting to be merged
+func (m *merger) delete(match pending) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, i := m.find(match)
+	if group == nil {
+		return false
+	}
+
+	m.remove(group.pages[i])
+	group.pages = append(group.pages[:i], group.pages[i+1:]...)
+	return true
+}
@@ -0,0 +1,244 @@
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanPage is a small png standing in for a scanned page
+func scanPage(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// merged is a document a merger delivered
+type merged struct {
+	realPath string
+	data     []byte
+}
+
+// mergeInto collects what a merger delivers, failing the first fails times
+func mergeInto(fails int) (chan merged, func(source, string, io.Reader) error) {
+	out := make(chan merged, 4)
+	return out, func(src source, realPath string, data io.Reader) error {
+		if fails > 0 {
+			fails--
+			return errors.New("spool is full")
+		}
+
+		buf, err := ioutil.ReadAll(data)
+		if err != nil {
+			return err
+		}
+		out <- merged{realPath, buf}
+		return nil
+	}
+}
+
+func addPage(t *testing.T, m *merger, session, name string, data []byte) {
+	t.Helper()
+
+	if _, isa, err := m.add(session, source{Session: session}, name, bytes.NewReader(data)); err != nil || !isa {
+		t.Fatalf("adding %s: %v %v", name, isa, err)
+	}
+}
+
+func waitMerged(t *testing.T, out chan merged) merged {
+	t.Helper()
+
+	select {
+	case doc := <-out:
+		return doc
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was merged")
+	}
+	return merged{}
+}
+
+// leftOver lists the page files still in a merge directory
+func leftOver(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestMerge(t *testing.T) {
+	root, spool := tempDir(t), tempDir(t)
+	factory := configured(t, nil, `
+[path.pages]
+type = "fs"
+root = "`+root+`"
+spool = "`+spool+`"
+merge = "session"
+merge_timeout = "10ms"
+`)
+	vp := factory.drivers["pages"]
+
+	d := session(t, factory)
+	for _, name := range []string{"/scan1.png", "/scan2.png"} {
+		if _, err := d.PutFile("/pages"+name, bytes.NewReader(scanPage(t)), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.PutFile("/pages/notes.txt", strings.NewReader("not a page"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); !exists(t, filepath.Join(root, "scan1.pdf")); {
+		if time.Now().After(deadline) {
+			t.Fatal("pages were never merged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	delivered(t, vp)
+
+	if got := content(t, filepath.Join(root, "scan1.pdf")); !strings.HasPrefix(got, "%PDF") {
+		t.Errorf("merged into %q", got)
+	}
+	if got := content(t, filepath.Join(root, "notes.txt")); got != "not a page" {
+		t.Errorf("notes are %q", got)
+	}
+	if files := leftOver(t, filepath.Join(spool, mergeDir)); len(files) != 0 {
+		t.Errorf("pages left behind %v", files)
+	}
+}
+
+// Pages waiting when scantp stops are merged once it starts again
+func TestMergeRestart(t *testing.T) {
+	spool := tempDir(t)
+
+	_, never := mergeInto(0)
+	m, err := newMerger("test", pathOptions{Merge: "session", Spool: spool, MergeTimeout: "1h"}, time.Millisecond, 0, never)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addPage(t, m, "a", "/scan1.png", scanPage(t))
+	addPage(t, m, "a", "/scan2.png", scanPage(t))
+	addPage(t, m, "b", "/other.png", scanPage(t))
+
+	out, deliver := mergeInto(0)
+	restarted, err := newMerger("test", pathOptions{Merge: "session", Spool: spool, MergeTimeout: "10ms"}, time.Millisecond, 0, deliver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		doc := waitMerged(t, out)
+		if !bytes.HasPrefix(doc.data, []byte("%PDF")) {
+			t.Errorf("%s isn't a pdf", doc.realPath)
+		}
+		got[doc.realPath] = true
+	}
+	if !got["/scan1.pdf"] || !got["/other.pdf"] {
+		t.Errorf("merged %v", got)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); len(leftOver(t, restarted.dir)) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("pages left behind")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMergeRetry(t *testing.T) {
+	out, deliver := mergeInto(2)
+	m, err := newMerger("test", pathOptions{Merge: "session", Spool: tempDir(t), MergeTimeout: "10ms"}, time.Millisecond, 0, deliver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addPage(t, m, "a", "/scan1.png", scanPage(t))
+
+	if doc := waitMerged(t, out); doc.realPath != "/scan1.pdf" {
+		t.Errorf("merged %s", doc.realPath)
+	}
+}
+
+func TestMergeUnreadable(t *testing.T) {
+	_, deliver := mergeInto(0)
+	m, err := newMerger("test", pathOptions{Merge: "session", Spool: tempDir(t), MergeTimeout: "10ms"}, time.Millisecond, 0, deliver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := make(chan error, 1)
+	m.failed = func(src source, realPath string, err error) {
+		failed <- err
+	}
+
+	addPage(t, m, "a", "/scan1.png", scanPage(t))
+	addPage(t, m, "a", "/scan2.png", []byte("not an image"))
+
+	select {
+	case err := <-failed:
+		if !errors.Is(err, errUnreadablePage) {
+			t.Errorf("failed with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("never gave up")
+	}
+
+	if files := leftOver(t, filepath.Join(m.dir, spoolFailedDir)); len(files) != 2 {
+		t.Errorf("%d pages in failed", len(files))
+	}
+	if files := leftOver(t, m.dir); len(files) != 0 {
+		t.Errorf("pages left behind %v", files)
+	}
+}
+
+func TestMergeNeedsSpool(t *testing.T) {
+	_, deliver := mergeInto(0)
+	if _, err := newMerger("test", pathOptions{Merge: "session"}, time.Millisecond, 0, deliver); err == nil {
+		t.Error("merged without a spool")
+	}
+}
+
+// Session numbers start again after a restart, so a new session with the
+// same number as one that left pages behind gets a document of its own
+func TestMergeRestartSessions(t *testing.T) {
+	spool := tempDir(t)
+
+	_, never := mergeInto(0)
+	m, err := newMerger("test", pathOptions{Merge: "session", Spool: spool, MergeTimeout: "1h"}, time.Millisecond, 0, never)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addPage(t, m, "1", "/theirs1.png", scanPage(t))
+	addPage(t, m, "1", "/theirs2.png", scanPage(t))
+
+	out, deliver := mergeInto(0)
+	restarted, err := newMerger("test", pathOptions{Merge: "session", Spool: spool, MergeTimeout: "50ms"}, time.Millisecond, 0, deliver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addPage(t, restarted, "1", "/mine.png", scanPage(t))
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		got[waitMerged(t, out).realPath] = true
+	}
+	if !got["/theirs1.pdf"] {
+		t.Errorf("pages left behind weren't merged, got %v", got)
+	}
+	if !got["/mine.pdf"] {
+		t.Errorf("new session's page joined the old document, got %v", got)
+	}
+}
@@ -0,0 +1,246 @@
+// Package notify tells people about uploads once they've landed, or that
+// they didn't. Notifiers are configured per path, in house notifiers can be
+// added with Register
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+
+	defaultTitle   = `{{if .Success}}Scan received{{else}}Scan failed{{end}}: {{.Name}}`
+	defaultMessage = `{{if .Success}}{{.File}} was delivered to {{.Path}}, {{.Bytes}} bytes{{else}}{{.File}} could not be delivered to {{.Path}}: {{.Error}}{{end}}`
+)
+
+// Event is an upload that landed or failed to
+type Event struct {
+	// Path is the name of the virtual path
+	Path string
+	// File is where the upload was written within the virtual path
+	File    string
+	User    string
+	Session string
+	Bytes   int64
+	// Error is why the upload failed, it's empty when it was delivered
+	Error string
+	Time  time.Time
+}
+
+// Success is true when the upload was delivered
+func (e Event) Success() bool {
+	return e.Error == ""
+}
+
+// Name is the file name without the directory
+func (e Event) Name() string {
+	return e.File[strings.LastIndex(e.File, "/")+1:]
+}
+
+// Message is an event along with the title and message templated for it
+type Message struct {
+	Event
+	Title string
+	Body  string
+}
+
+// Sender delivers a message somewhere people will see it
+type Sender interface {
+	Send(msg *Message) error
+}
+
+// Factory creates a sender, fn decodes the notifier's configuration
+type Factory func(fn func(v interface{}) error) (Sender, error)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a notifier available to the configuration under name
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic("notify: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Names lists the registered notifiers
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// on picks which events are sent
+type on int
+
+const (
+	onAll on = iota
+	onSuccess
+	onFailure
+)
+
+func parseOn(s string) (on, error) {
+	switch s {
+	case "", "all":
+		return onAll, nil
+	case "success":
+		return onSuccess, nil
+	case "failure":
+		return onFailure, nil
+	}
+	return onAll, fmt.Errorf("unknown on %q, expected all, success or failure", s)
+}
+
+// Notifier templates events and hands them to its sender
+type Notifier struct {
+	name    string
+	sender  Sender
+	on      on
+	title   *template.Template
+	message *template.Template
+}
+
+// New creates the notifier named by the type in its configuration
+func New(fn func(v interface{}) error) (*Notifier, error) {
+	var common struct {
+		Type string `toml:"type"`
+	}
+	if err := fn(&common); err != nil {
+		return nil, err
+	}
+
+	return NewType(common.Type, fn)
+}
+
+// NewType creates a notifier of the given type, for configuration where
+// type already means something else
+func NewType(kind string, fn func(v interface{}) error) (*Notifier, error) {
+	common := struct {
+		On      string `toml:"on"`
+		Title   string `toml:"title"`
+		Message string `toml:"message"`
+	}{
+		Title:   defaultTitle,
+		Message: defaultMessage,
+	}
+	if err := fn(&common); err != nil {
+		return nil, err
+	}
+
+	registryMu.Lock()
+	factory, exists := registry[kind]
+	registryMu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown notifier %q, expected one of %s", kind, strings.Join(Names(), ", "))
+	}
+
+	n := &Notifier{name: kind}
+
+	var err error
+	if n.on, err = parseOn(common.On); err != nil {
+		return nil, fmt.Errorf("notifier %s: %w", kind, err)
+	}
+	if n.title, err = template.New("title").Parse(common.Title); err != nil {
+		return nil, fmt.Errorf("notifier %s: failure while parsing title: %w", kind, err)
+	}
+	if n.message, err = template.New("message").Parse(common.Message); err != nil {
+		return nil, fmt.Errorf("notifier %s: failure while parsing message: %w", kind, err)
+	}
+
+	if n.sender, err = factory(fn); err != nil {
+		return nil, fmt.Errorf("notifier %s: %w", kind, err)
+	}
+
+	return n, nil
+}
+
+// Notify sends the event if the notifier wants it
+func (n *Notifier) Notify(e Event) error {
+	if (n.on == onSuccess && !e.Success()) || (n.on == onFailure && e.Success()) {
+		return nil
+	}
+
+	msg := &Message{Event: e}
+
+	var buf bytes.Buffer
+	if err := n.title.Execute(&buf, e); err != nil {
+		return fmt.Errorf("failure while templating title: %w", err)
+	}
+	msg.Title = buf.String()
+
+	buf.Reset()
+	if err := n.message.Execute(&buf, e); err != nil {
+		return fmt.Errorf("failure while templating message: %w", err)
+	}
+	msg.Body = buf.String()
+
+	return n.sender.Send(msg)
+}
+
+// List is every notifier configured for a path
+type List []*Notifier
+
+// Notify sends the event to every notifier in the background so uploads
+// aren't held up, failing to notify is only logged
+func (l List) Notify(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	for _, n := range l {
+		go func(n *Notifier) {
+			if err := n.Notify(e); err != nil {
+				logrus.WithFields(logrus.Fields{"path": e.Path, "file": e.File, "notifier": n.name}).WithError(err).Warn("unable to notify")
+			}
+		}(n)
+	}
+}
+
+// post sends a request and treats anything but a 2xx as a failure
+func post(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// parseTimeout reads a timeout option, defaulting to defaultTimeout
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("failure while parsing timeout: %w", err)
+	}
+	return timeout, nil
+}
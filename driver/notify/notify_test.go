@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func configure(config string) func(v interface{}) error {
+	return func(v interface{}) error {
+		_, err := toml.Decode(config, v)
+		return err
+	}
+}
+
+// hook is a webhook receiver collecting what it was sent
+func hook(t *testing.T) (string, chan map[string]interface{}) {
+	t.Helper()
+
+	got := make(chan map[string]interface{}, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "no token", http.StatusUnauthorized)
+			return
+		}
+		got <- payload
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, got
+}
+
+func TestWebhook(t *testing.T) {
+	url, got := hook(t)
+	n, err := New(configure(`type = "webhook"
+url = "` + url + `"
+title = "{{.User}} sent {{.Name}}"
+[headers]
+X-Token = "secret"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Notify(Event{Path: "docs", File: "/2020/scan.pdf", User: "alice", Session: "abc", Bytes: 4, Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := <-got
+	want := map[string]interface{}{
+		"path":    "docs",
+		"file":    "/2020/scan.pdf",
+		"user":    "alice",
+		"session": "abc",
+		"success": true,
+		"title":   "alice sent scan.pdf",
+		"message": "/2020/scan.pdf was delivered to docs, 4 bytes",
+	}
+	for k, v := range want {
+		if payload[k] != v {
+			t.Errorf("%s is %v, expected %v", k, payload[k], v)
+		}
+	}
+}
+
+func TestOn(t *testing.T) {
+	url, got := hook(t)
+
+	tests := map[string][]bool{
+		"all":     {true, true},
+		"success": {true, false},
+		"failure": {false, true},
+	}
+	for on, sent := range tests {
+		n, err := New(configure("type = \"webhook\"\nurl = \"" + url + "\"\non = \"" + on + "\"\n[headers]\nX-Token = \"secret\""))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, e := range []Event{{File: "/ok.pdf"}, {File: "/failed.pdf", Error: "refused"}} {
+			if err := n.Notify(e); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case <-got:
+				if !sent[i] {
+					t.Errorf("%s sent %s", on, e.File)
+				}
+			default:
+				if sent[i] {
+					t.Errorf("%s didn't send %s", on, e.File)
+				}
+			}
+		}
+	}
+}
+
+// NewType is for configuration where type is already taken, the notify
+// processor for one
+func TestNewType(t *testing.T) {
+	url, _ := hook(t)
+	n, err := NewType("webhook", configure(`type = "notify"
+url = "`+url+`"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.name != "webhook" {
+		t.Errorf("made a %s notifier", n.name)
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	tests := map[string]string{
+		"unknown type": `type = "pigeon"`,
+		"bad on":       "type = \"webhook\"\nurl = \"http://localhost\"\non = \"sometimes\"",
+		"bad title":    "type = \"webhook\"\nurl = \"http://localhost\"\ntitle = \"{{.Name\"",
+		"no url":       `type = "webhook"`,
+	}
+
+	for name, config := range tests {
+		if _, err := New(configure(config)); err == nil {
+			t.Errorf("%s: configuration was accepted", name)
+		}
+	}
+}
+
+// Everyone the email goes to is named in one To header
+func TestSMTPMessage(t *testing.T) {
+	s, err := newSMTP(configure(`host = "localhost"
+from = "scantp@example.com"
+to = ["alice@example.com", "bob@example.com"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := string(s.(*smtpSender).message(&Message{Event: Event{Time: time.Now()}, Title: "Delivered", Body: "scan.pdf"}))
+	if n := strings.Count(msg, "To: "); n != 1 {
+		t.Errorf("%d To headers", n)
+	}
+	if !strings.Contains(msg, "To: alice@example.com, bob@example.com\r\n") {
+		t.Errorf("recipients missing from %q", msg)
+	}
+}
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("ntfy", newNtfy)
+	Register("gotify", newGotify)
+}
+
+// push is the configuration ntfy and gotify share
+type push struct {
+	url     string
+	token   string
+	success int
+	failure int
+	client  *http.Client
+}
+
+func newPush(fn func(v interface{}) error, success, failure int) (*push, error) {
+	config := struct {
+		URL             string `toml:"url"`
+		Token           string `toml:"token"`
+		Priority        int    `toml:"priority"`
+		FailurePriority int    `toml:"failure_priority"`
+		Timeout         string `toml:"timeout"`
+	}{
+		Priority:        success,
+		FailurePriority: failure,
+	}
+	if err := fn(&config); err != nil {
+		return nil, err
+	}
+
+	if config.URL == "" {
+		return nil, errors.New("url is required")
+	}
+
+	timeout, err := parseTimeout(config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &push{
+		url:     config.URL,
+		token:   config.Token,
+		success: config.Priority,
+		failure: config.FailurePriority,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (p *push) priority(msg *Message) int {
+	if msg.Success() {
+		return p.success
+	}
+	return p.failure
+}
+
+// ntfy publishes to a topic, url is the topic's url
+type ntfy struct {
+	*push
+}
+
+func newNtfy(fn func(v interface{}) error) (Sender, error) {
+	p, err := newPush(fn, 3, 5)
+	if err != nil {
+		return nil, err
+	}
+	return &ntfy{p}, nil
+}
+
+func (n *ntfy) Send(msg *Message) error {
+	req, err := http.NewRequest(http.MethodPost, n.url, strings.NewReader(msg.Body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Title", msg.Title)
+	req.Header.Set("Priority", strconv.Itoa(n.priority(msg)))
+	if !msg.Success() {
+		req.Header.Set("Tags", "warning")
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+
+	return post(n.client, req)
+}
+
+// gotify sends to a gotify server, url is the server's address and token
+// is an application token
+type gotify struct {
+	*push
+}
+
+func newGotify(fn func(v interface{}) error) (Sender, error) {
+	p, err := newPush(fn, 5, 8)
+	if err != nil {
+		return nil, err
+	}
+	if p.token == "" {
+		return nil, errors.New("token is required")
+	}
+	return &gotify{p}, nil
+}
+
+func (g *gotify) Send(msg *Message) error {
+	body, err := json.Marshal(struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}{
+		Title:    msg.Title,
+		Message:  msg.Body,
+		Priority: g.priority(msg),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(g.url, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.token)
+
+	return post(g.client, req)
+}
@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/freman/scantp/driver/email"
+)
+
+func init() {
+	Register("smtp", newSMTP)
+}
+
+// smtpSender emails the message through a relay
+type smtpSender struct {
+	relay *email.Relay
+	from  string
+	to    []string
+}
+
+func newSMTP(fn func(v interface{}) error) (Sender, error) {
+	var config struct {
+		email.RelayConfig
+		From string   `toml:"from"`
+		To   []string `toml:"to"`
+	}
+	if err := fn(&config); err != nil {
+		return nil, err
+	}
+
+	if config.From == "" {
+		return nil, errors.New("from is required")
+	}
+	if len(config.To) == 0 {
+		return nil, errors.New("to is required")
+	}
+
+	relay, err := email.NewRelay(config.RelayConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &smtpSender{relay: relay, from: config.From, to: config.To}, nil
+}
+
+func (s *smtpSender) Send(msg *Message) error {
+	return s.relay.Send(s.from, s.to, s.message(msg))
+}
+
+// message builds the email, everyone it's sent to shares the one To header
+func (s *smtpSender) message(msg *Message) []byte {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", s.from)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Title))
+	fmt.Fprintf(&body, "Date: %s\r\n", msg.Time.Format(time.RFC1123Z))
+	body.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	body.WriteString(msg.Body)
+	body.WriteString("\r\n")
+	return body.Bytes()
+}
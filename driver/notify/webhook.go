@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+func init() {
+	Register("webhook", newWebhook)
+}
+
+// webhook posts the message as json
+type webhook struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newWebhook(fn func(v interface{}) error) (Sender, error) {
+	var config struct {
+		URL     string            `toml:"url"`
+		Headers map[string]string `toml:"headers"`
+		Timeout string            `toml:"timeout"`
+	}
+	if err := fn(&config); err != nil {
+		return nil, err
+	}
+
+	if config.URL == "" {
+		return nil, errors.New("url is required")
+	}
+
+	timeout, err := parseTimeout(config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook{
+		url:     config.URL,
+		headers: config.Headers,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (w *webhook) Send(msg *Message) error {
+	payload := struct {
+		Path    string    `json:"path"`
+		File    string    `json:"file"`
+		User    string    `json:"user,omitempty"`
+		Session string    `json:"session,omitempty"`
+		Bytes   int64     `json:"bytes"`
+		Success bool      `json:"success"`
+		Error   string    `json:"error,omitempty"`
+		Time    time.Time `json:"time"`
+		Title   string    `json:"title"`
+		Message string    `json:"message"`
+	}{
+		Path:    msg.Path,
+		File:    msg.File,
+		User:    msg.User,
+		Session: msg.Session,
+		Bytes:   msg.Bytes,
+		Success: msg.Success(),
+		Error:   msg.Error,
+		Time:    msg.Time,
+		Title:   msg.Title,
+		Message: msg.Body,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	return post(w.client, req)
+}
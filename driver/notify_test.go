@@ -0,0 +1,173 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freman/scantp/driver/notify"
+)
+
+// recorded is every event sent to a "recorder" notifier
+var recorded = make(chan notify.Event, 16)
+
+type recorder struct{}
+
+func (recorder) Send(msg *notify.Message) error {
+	recorded <- msg.Event
+	return nil
+}
+
+func init() {
+	notify.Register("recorder", func(fn func(v interface{}) error) (notify.Sender, error) {
+		return recorder{}, nil
+	})
+}
+
+// events waits for n events, then makes sure no more turn up
+func events(t *testing.T, n int) []notify.Event {
+	t.Helper()
+
+	var got []notify.Event
+	for len(got) < n {
+		select {
+		case e := <-recorded:
+			got = append(got, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d events, expected %d", len(got), n)
+		}
+	}
+
+	select {
+	case e := <-recorded:
+		t.Fatalf("unexpected event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+	return got
+}
+
+func TestNotifyDelivered(t *testing.T) {
+	root := tempDir(t)
+	factory := configured(t, nil, `
+[path.docs]
+type = "fs"
+root = "`+root+`"
+on_conflict = "rename"
+
+[[path.docs.notify]]
+type = "recorder"
+`)
+	if err := ioutil.WriteFile(filepath.Join(root, "scan.pdf"), []byte("someone else's"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := session(t, factory)
+	if _, err := d.PutFile("/docs/scan.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	e := events(t, 1)[0]
+	if !e.Success() || e.File != "/scan-1.pdf" || e.Session != d.session || e.Bytes != 4 {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestNotifyRefused(t *testing.T) {
+	root := tempDir(t)
+	factory := configured(t, nil, `
+[path.docs]
+type = "fs"
+root = "`+root+`"
+on_conflict = "reject"
+
+[[path.docs.notify]]
+type = "recorder"
+`)
+	if err := ioutil.WriteFile(filepath.Join(root, "scan.pdf"), []byte("someone else's"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := session(t, factory)
+	if _, err := d.PutFile("/docs/scan.pdf", strings.NewReader("mine"), false); err == nil {
+		t.Fatal("upload wasn't refused")
+	}
+
+	e := events(t, 1)[0]
+	if e.Success() || e.File != "/scan.pdf" || e.Session != d.session {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+// Spooled uploads are only notified once they've been delivered
+func TestNotifySpooled(t *testing.T) {
+	root, spool := tempDir(t), tempDir(t)
+	factory := configured(t, nil, `
+[path.notified]
+type = "fs"
+root = "`+root+`"
+spool = "`+spool+`"
+
+[[path.notified.notify]]
+type = "recorder"
+`)
+	vp := factory.drivers["notified"]
+	release := hold(t, vp)
+
+	d := session(t, factory)
+	if _, err := d.PutFile("/notified/scan.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+	events(t, 0)
+
+	release()
+	got := events(t, 2)
+	for _, e := range got {
+		if !e.Success() {
+			t.Errorf("unexpected event %+v", e)
+		}
+		if e.File == "/scan.pdf" && e.Session != d.session {
+			t.Errorf("event lost the session %+v", e)
+		}
+	}
+}
+
+// Spooled uploads that can never be delivered are given up on and notified
+// straight away rather than retried until they're too old
+func TestNotifyPermanentFailure(t *testing.T) {
+	root, spool := tempDir(t), tempDir(t)
+	factory := configured(t, nil, `
+[path.docs]
+type = "fs"
+root = "`+root+`"
+spool = "`+spool+`"
+on_conflict = "reject"
+
+[[path.docs.notify]]
+type = "recorder"
+`)
+	if err := os.Mkdir(filepath.Join(root, "scan.pdf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	d := session(t, factory)
+	if _, err := d.PutFile("/docs/scan.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	e := events(t, 1)[0]
+	if e.Success() || e.File != "/scan.pdf" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	delivered(t, factory.drivers["docs"])
+
+	failed, err := filepath.Glob(filepath.Join(spool, spoolFailedDir, "*.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 {
+		t.Errorf("%d files in failed", len(failed))
+	}
+}
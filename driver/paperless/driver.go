@@ -0,0 +1,332 @@
+// Package paperless uploads documents to Paperless-ngx through its
+// post_document endpoint, where they're consumed like anything else
+package paperless
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/freman/scantp/driver/retry"
+	"github.com/freman/scantp/driver/sent"
+	"goftp.io/server"
+)
+
+const (
+	defaultTimeout = 10 * time.Minute
+)
+
+type Driver struct {
+	baseURL       *url.URL
+	authorization string
+	title         *template.Template
+	httpClient    *http.Client
+
+	// ids caches what names of correspondents, document types and tags
+	// resolved to
+	idsMu sync.Mutex
+	ids   map[string]int
+
+	sent *sent.Files
+
+	configuration struct {
+		URL           string   `toml:"url"`
+		Token         string   `toml:"token"`
+		Username      string   `toml:"username"`
+		Password      string   `toml:"password"`
+		Title         string   `toml:"title"`
+		Correspondent string   `toml:"correspondent"`
+		DocumentType  string   `toml:"document_type"`
+		Tags          []string `toml:"tags"`
+		Timeout       string   `toml:"timeout"`
+	}
+}
+
+// templateData is what the title can refer to
+type templateData struct {
+	Path string
+	Name string
+	// Base is the name without its extension
+	Base string
+	Dir  string
+	Now  time.Time
+}
+
+func (d *Driver) Stat(p string) (server.FileInfo, error) {
+	return d.sent.Stat(p)
+}
+
+// ListDir is always empty, documents belong to paperless once they're sent
+func (d *Driver) ListDir(p string, fn func(server.FileInfo) error) error {
+	return nil
+}
+
+// MakeDir succeeds so scanners and templates can use folders, the folder is
+// available to the title as {{.Dir}}
+func (d *Driver) MakeDir(p string) error {
+	d.sent.AddDir(p)
+	return nil
+}
+
+func (d *Driver) PutFile(p string, stream io.Reader, appendData bool) (int64, error) {
+	if appendData {
+		return 0, errors.New("Appending to files is not supported")
+	}
+
+	ctx := context.TODO()
+	p = path.Clean("/" + p)
+	name := path.Base(p)
+
+	fields := url.Values{}
+	if d.title != nil {
+		var title bytes.Buffer
+		if err := d.title.Execute(&title, templateData{
+			Path: p,
+			Name: name,
+			Base: strings.TrimSuffix(name, path.Ext(name)),
+			Dir:  path.Dir(p),
+			Now:  time.Now(),
+		}); err != nil {
+			return 0, fmt.Errorf("failure while templating title: %w", err)
+		}
+		fields.Set("title", title.String())
+	}
+
+	if d.configuration.Correspondent != "" {
+		id, err := d.resolve(ctx, "correspondents", d.configuration.Correspondent)
+		if err != nil {
+			return 0, err
+		}
+		fields.Set("correspondent", strconv.Itoa(id))
+	}
+
+	if d.configuration.DocumentType != "" {
+		id, err := d.resolve(ctx, "document_types", d.configuration.DocumentType)
+		if err != nil {
+			return 0, err
+		}
+		fields.Set("document_type", strconv.Itoa(id))
+	}
+
+	for _, tag := range d.configuration.Tags {
+		id, err := d.resolve(ctx, "tags", tag)
+		if err != nil {
+			return 0, err
+		}
+		fields.Add("tags", strconv.Itoa(id))
+	}
+
+	// Stream the multipart body through a pipe so the upload is never held in
+	// memory
+	body, pipe := io.Pipe()
+	defer body.Close()
+	writer := multipart.NewWriter(pipe)
+	counter := &sent.CountingReader{Reader: stream}
+	copied := make(chan error, 1)
+	go func() {
+		var err error
+		for field, values := range fields {
+			for _, value := range values {
+				if err == nil {
+					err = writer.WriteField(field, value)
+				}
+			}
+		}
+		if err == nil {
+			var part io.Writer
+			if part, err = writer.CreateFormFile("document", name); err == nil {
+				_, err = io.Copy(part, counter)
+			}
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pipe.CloseWithError(err)
+		copied <- err
+	}()
+
+	req, err := d.newRequest(ctx, http.MethodPost, "api/documents/post_document/", body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	// Paperless answers with the id of the task that will consume it
+	var task string
+	if err := d.doRequest(req, jsonResponse(&task)); err != nil {
+		return 0, err
+	}
+	if err := <-copied; err != nil {
+		return 0, err
+	}
+
+	d.sent.Remember(p, counter.N)
+
+	return counter.N, nil
+}
+
+// resolve turns the name of a correspondent, document type or tag into its
+// id, ids are used as they are
+func (d *Driver) resolve(ctx context.Context, kind, name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	key := kind + "/" + name
+	d.idsMu.Lock()
+	id, isa := d.ids[key]
+	d.idsMu.Unlock()
+	if isa {
+		return id, nil
+	}
+
+	req, err := d.newRequest(ctx, http.MethodGet, "api/"+kind+"/?"+url.Values{"name__iexact": []string{name}}.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var result struct {
+		Results []struct {
+			ID int `json:"id"`
+		} `json:"results"`
+	}
+	if err := d.doRequest(req, jsonResponse(&result)); err != nil {
+		return 0, fmt.Errorf("looking up %s %q: %w", kind, name, err)
+	}
+	if len(result.Results) == 0 {
+		return 0, fmt.Errorf("paperless has no %s named %q", strings.TrimSuffix(kind, "s"), name)
+	}
+
+	id = result.Results[0].ID
+	d.idsMu.Lock()
+	d.ids[key] = id
+	d.idsMu.Unlock()
+
+	return id, nil
+}
+
+func (d *Driver) newRequest(ctx context.Context, method string, uri string, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, d.baseURL.ResolveReference(u).String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", d.authorization)
+	return req, nil
+}
+
+func (d *Driver) doRequest(r *http.Request, fn func(r *http.Response) error) error {
+	resp, err := d.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return fn(resp)
+	}
+
+	detail, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return &statusError{code: resp.StatusCode, status: resp.Status, body: strings.TrimSpace(string(detail))}
+}
+
+func jsonResponse(v interface{}) func(r *http.Response) error {
+	return func(r *http.Response) error {
+		return json.NewDecoder(r.Body).Decode(v)
+	}
+}
+
+// statusError is returned for api requests that didn't succeed, paperless
+// explains what was wrong with the upload in the body
+type statusError struct {
+	code   int
+	status string
+	body   string
+}
+
+func (e *statusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("API http status error %s", e.status)
+	}
+	return fmt.Sprintf("API http status error %s: %s", e.status, e.body)
+}
+
+// Is makes requests the server refused as wrong permanent failures, sending
+// them again won't change its mind
+func (e *statusError) Is(target error) bool {
+	return target == retry.ErrPermanent && retry.PermanentStatus(e.code)
+}
+
+func (d *Driver) Rename(from, to string) error {
+	return errors.New("Permission Denied, documents can't be renamed once they're sent")
+}
+
+func (d *Driver) DeleteFile(p string) error {
+	return errors.New("Permission Denied, documents can't be deleted once they're sent")
+}
+
+func NewDriver(fn func(v interface{}) error) (d *Driver, err error) {
+	d = &Driver{
+		ids:  make(map[string]int),
+		sent: sent.New(),
+	}
+	if err = fn(&d.configuration); err != nil {
+		return nil, err
+	}
+
+	if d.configuration.URL == "" {
+		return nil, errors.New("Configuration for paperless is invalid, required url")
+	}
+
+	// The api is relative to the url so make sure it's treated as a directory
+	if d.baseURL, err = url.Parse(strings.TrimSuffix(d.configuration.URL, "/") + "/"); err != nil {
+		return nil, fmt.Errorf(`failure while parsing paperless url: %w`, err)
+	}
+
+	switch {
+	case d.configuration.Token != "" && d.configuration.Username != "":
+		return nil, errors.New("Configuration for paperless is invalid, use either token or username and password, not both")
+	case d.configuration.Token != "":
+		d.authorization = "Token " + d.configuration.Token
+	case d.configuration.Username != "":
+		d.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(d.configuration.Username+":"+d.configuration.Password))
+	default:
+		return nil, errors.New("Configuration for paperless is invalid, required token")
+	}
+
+	if d.configuration.Title != "" {
+		if d.title, err = template.New("title").Parse(d.configuration.Title); err != nil {
+			return nil, fmt.Errorf("failure while parsing title: %w", err)
+		}
+	}
+
+	timeout := defaultTimeout
+	if d.configuration.Timeout != "" {
+		if timeout, err = time.ParseDuration(d.configuration.Timeout); err != nil {
+			return nil, fmt.Errorf("failure while parsing timeout: %w", err)
+		}
+	}
+	d.httpClient = &http.Client{Timeout: timeout}
+
+	return d, nil
+}
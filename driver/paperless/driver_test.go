@@ -0,0 +1,224 @@
+package paperless
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/freman/scantp/driver/retry"
+)
+
+func newDriver(t *testing.T, config string) (*Driver, error) {
+	t.Helper()
+
+	var prim toml.Primitive
+	md, err := toml.Decode(config, &prim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewDriver(func(v interface{}) error {
+		return md.PrimitiveDecode(prim, v)
+	})
+}
+
+// fakePaperless answers the parts of the api the driver uses
+type fakePaperless struct {
+	mu        sync.Mutex
+	lookups   int
+	documents []map[string][]string
+	files     []string
+}
+
+func (f *fakePaperless) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Token secret" {
+		http.Error(w, `{"detail":"Invalid token."}`, http.StatusUnauthorized)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/paperless/api/tags/", "/paperless/api/correspondents/":
+		f.lookups++
+		ids := map[string]int{"inbox": 1, "Scans": 2, "ACME": 3}
+		var result struct {
+			Results []map[string]int `json:"results"`
+		}
+		result.Results = []map[string]int{}
+		if id, isa := ids[r.URL.Query().Get("name__iexact")]; isa {
+			result.Results = append(result.Results, map[string]int{"id": id})
+		}
+		json.NewEncoder(w).Encode(result)
+	case "/paperless/api/documents/post_document/":
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		file, _, err := r.FormFile("document")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		buf, _ := ioutil.ReadAll(file)
+		f.documents = append(f.documents, r.MultipartForm.Value)
+		f.files = append(f.files, string(buf))
+		w.Write([]byte(`"8c7d6e5f"`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPutFile(t *testing.T) {
+	fake := &fakePaperless{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	d, err := newDriver(t, `url = "`+srv.URL+`/paperless"
+token = "secret"
+title = "{{.Base}} from {{.Dir}}"
+correspondent = "ACME"
+tags = ["inbox", "Scans", "7"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		n, err := d.PutFile("/invoices/scan.pdf", strings.NewReader("%PDF"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 4 {
+			t.Errorf("sent %d bytes, expected 4", n)
+		}
+	}
+
+	if fake.lookups != 3 {
+		t.Errorf("looked up names %d times, expected them to be remembered after 3", fake.lookups)
+	}
+	if len(fake.documents) != 2 || fake.files[0] != "%PDF" {
+		t.Fatalf("paperless got %v", fake.files)
+	}
+	fields := fake.documents[0]
+	if got := fields["title"]; len(got) != 1 || got[0] != "scan from /invoices" {
+		t.Errorf("title was %v", got)
+	}
+	if got := fields["correspondent"]; len(got) != 1 || got[0] != "3" {
+		t.Errorf("correspondent was %v", got)
+	}
+	if got := strings.Join(fields["tags"], ","); got != "1,2,7" {
+		t.Errorf("tags were %v", got)
+	}
+
+	if info, err := d.Stat("/invoices/scan.pdf"); err != nil || info.Size() != 4 {
+		t.Errorf("stat of the sent file gave %v, %v", info, err)
+	}
+	if info, err := d.Stat("/invoices"); err != nil || !info.IsDir() {
+		t.Errorf("stat of the folder it was sent to gave %v, %v", info, err)
+	}
+}
+
+func TestUnknownTag(t *testing.T) {
+	srv := httptest.NewServer(&fakePaperless{})
+	defer srv.Close()
+
+	d, err := newDriver(t, `url = "`+srv.URL+`/paperless/"
+token = "secret"
+tags = ["missing"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.PutFile("/scan.pdf", strings.NewReader("%PDF"), false); err == nil || !strings.Contains(err.Error(), `no tag named "missing"`) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(&fakePaperless{})
+	defer srv.Close()
+
+	d, err := newDriver(t, `url = "`+srv.URL+`/paperless"
+token = "wrong"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.PutFile("/scan.pdf", strings.NewReader("%PDF"), false)
+	var status *statusError
+	if !errors.As(err, &status) || status.code != http.StatusUnauthorized || !strings.Contains(err.Error(), "Invalid token") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !errors.Is(err, retry.ErrPermanent) {
+		t.Error("the wrong token is worth trying again")
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("scanner went away")
+}
+
+func TestReadError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Accept without reading so only the copy knows something went wrong
+		w.Write([]byte(`"8c7d6e5f"`))
+	}))
+	defer srv.Close()
+
+	d, err := newDriver(t, `url = "`+srv.URL+`"
+token = "secret"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.PutFile("/scan.pdf", io.MultiReader(strings.NewReader("%PDF"), failingReader{}), false); err == nil {
+		t.Error("upload succeeded even though it couldn't be read")
+	}
+	if _, err := d.Stat("/scan.pdf"); err == nil {
+		t.Error("the failed upload was remembered")
+	}
+}
+
+func TestMakeDir(t *testing.T) {
+	d, err := newDriver(t, `url = "http://localhost"
+token = "secret"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.MakeDir("/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/", "/a", "/a/b"} {
+		if info, err := d.Stat(p); err != nil || !info.IsDir() {
+			t.Errorf("stat of %s gave %v, %v", p, info, err)
+		}
+	}
+	if _, err := d.Stat("/c"); err == nil {
+		t.Error("stat of a folder that was never made succeeded")
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	for _, config := range []string{
+		``,
+		`url = "http://localhost"`,
+		"url = \"http://localhost\"\ntoken = \"a\"\nusername = \"b\"",
+		"url = \"http://localhost\"\ntoken = \"a\"\ntitle = \"{{.Nope\"",
+		"url = \"http://localhost\"\ntoken = \"a\"\ntimeout = \"soon\"",
+	} {
+		if _, err := newDriver(t, config); err == nil {
+			t.Errorf("%q was accepted", config)
+		}
+	}
+}
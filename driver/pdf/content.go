@@ -0,0 +1,173 @@
+package pdf
+
+import (
+	"bytes"
+	"math"
+)
+
+// matrix is a PDF transformation matrix [a b c d e f]
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// multiply gives the matrix that applies m and then n
+func (m matrix) multiply(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// area is the size of the box around the unit square once transformed,
+// images are drawn into the unit square
+func (m matrix) area() float64 {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range [][2]float64{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		x := m[0]*p[0] + m[2]*p[1] + m[4]
+		y := m[1]*p[0] + m[3]*p[1] + m[5]
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	return (maxX - minX) * (maxY - minY)
+}
+
+// ImageCoverage is how much of the page the image Decode looks at is drawn
+// over, from 0 to 1. Scans fill the page, a logo on a page of text doesn't
+func (p *Page) ImageCoverage() float64 {
+	if p.image == nil || p.Width <= 0 || p.Height <= 0 {
+		return 0
+	}
+
+	var data []byte
+	contents := p.r.resolve(p.dict["Contents"])
+	if arr, isa := contents.(array); isa {
+		for _, obj := range arr {
+			if s, isa := p.r.resolve(obj).(*stream); isa {
+				if decoded, err := p.r.decodeStream(s, false); err == nil {
+					data = append(append(data, decoded...), '\n')
+				}
+			}
+		}
+	} else if s, isa := contents.(*stream); isa {
+		data, _ = p.r.decodeStream(s, false)
+	}
+
+	area := p.r.imageArea(p.image, data, p.r.dict(p.inherited["Resources"]), identity, 0, make(map[*stream]bool))
+	return math.Min(area/(p.Width*p.Height), 1)
+}
+
+// imageArea finds the largest area img is drawn over by the content, looking
+// inside forms the same way largestImage does. Anything it can't make sense
+// of ends the search with what's been found so far
+func (r *Reader) imageArea(img *stream, content []byte, resources dict, ctm matrix, depth int, seen map[*stream]bool) float64 {
+	if depth > 4 {
+		return 0
+	}
+
+	var largest float64
+	var operands []interface{}
+	var saved []matrix
+	for pos := skip(content, 0); pos < len(content); pos = skip(content, pos) {
+		c := content[pos]
+		if isDelimiter(c) || c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9') {
+			obj, end, err := parse(content, pos, r.length)
+			if err != nil {
+				return largest
+			}
+			operands = append(operands, obj)
+			pos = end
+			continue
+		}
+
+		op, end := token(content, pos)
+		pos = end
+
+		switch op {
+		case "q":
+			saved = append(saved, ctm)
+		case "Q":
+			if len(saved) > 0 {
+				ctm, saved = saved[len(saved)-1], saved[:len(saved)-1]
+			}
+		case "cm":
+			if m, isa := r.matrix(operands); isa {
+				ctm = m.multiply(ctm)
+			}
+		case "Do":
+			if len(operands) == 0 {
+				break
+			}
+			n, _ := operands[len(operands)-1].(name)
+			s, isa := r.resolve(r.dict(resources["XObject"])[n]).(*stream)
+			if !isa {
+				break
+			}
+
+			switch {
+			case s == img:
+				largest = math.Max(largest, ctm.area())
+			case s.dict["Subtype"] == name("Form") && !seen[s]:
+				seen[s] = true
+				m, isa := r.matrix(r.resolve(s.dict["Matrix"]))
+				if !isa {
+					m = identity
+				}
+				formResources := r.dict(s.dict["Resources"])
+				if formResources == nil {
+					formResources = resources
+				}
+				if data, err := r.decodeStream(s, false); err == nil {
+					largest = math.Max(largest, r.imageArea(img, data, formResources, m.multiply(ctm), depth+1, seen))
+				}
+			}
+		case "ID":
+			// Inline image data is binary, skip to the EI that ends it
+			end := bytes.Index(content[pos:], []byte("EI"))
+			for end >= 0 && pos+end+2 < len(content) && !isSpace(content[pos+end+2]) {
+				next := bytes.Index(content[pos+end+2:], []byte("EI"))
+				if next < 0 {
+					end = -1
+					break
+				}
+				end += next + 2
+			}
+			if end < 0 {
+				return largest
+			}
+			pos += end + 2
+		}
+		operands = operands[:0]
+	}
+
+	return largest
+}
+
+// matrix reads the six numbers of a matrix, either the operands of cm or an
+// array such as a form's Matrix
+func (r *Reader) matrix(obj interface{}) (matrix, bool) {
+	var values []interface{}
+	switch v := obj.(type) {
+	case []interface{}:
+		values = v
+	case array:
+		values = v
+	}
+	if len(values) < 6 {
+		return matrix{}, false
+	}
+
+	var m matrix
+	for i, v := range values[len(values)-6:] {
+		n, isa := r.number(v)
+		if !isa {
+			return matrix{}, false
+		}
+		m[i] = n
+	}
+	return m, true
+}
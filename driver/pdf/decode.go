@@ -0,0 +1,490 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/image/ccitt"
+)
+
+// ErrUnsupported is returned for images encoded in a way we can't decode
+var ErrUnsupported = errors.New("unsupported image encoding")
+
+const (
+	// maxDimension is the most pixels an image can be across or down, a
+	// page at 1200 dpi is still well under it
+	maxDimension = 1 << 16
+
+	// maxDecoded is the most a stream may decompress to
+	maxDecoded = 1 << 28
+)
+
+// checkSize makes sure an image is small enough to hold in memory
+func checkSize(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return errors.New("image has no size")
+	}
+	if width > maxDimension || height > maxDimension {
+		return fmt.Errorf("%w: %dx%d pixels is too big", ErrUnsupported, width, height)
+	}
+	return nil
+}
+
+// checkDepth makes sure the bits per component is one the format allows
+func checkDepth(bpc int) error {
+	switch bpc {
+	case 1, 2, 4, 8, 16:
+		return nil
+	}
+	return fmt.Errorf("%w: %d bits per component", ErrUnsupported, bpc)
+}
+
+// filters lists the filters applied to a stream along with their parameters
+func (r *Reader) filters(s *stream) ([]name, []dict) {
+	var filters []name
+	var params []dict
+
+	switch f := r.resolve(s.dict["Filter"]).(type) {
+	case name:
+		filters = []name{f}
+		params = []dict{r.dict(s.dict["DecodeParms"])}
+	case array:
+		p, _ := r.resolve(s.dict["DecodeParms"]).(array)
+		for i, v := range f {
+			n, _ := r.resolve(v).(name)
+			filters = append(filters, n)
+			if i < len(p) {
+				params = append(params, r.dict(p[i]))
+			} else {
+				params = append(params, nil)
+			}
+		}
+	}
+
+	return filters, params
+}
+
+// decodeStream undoes the general purpose filters on a stream. If image is
+// set it stops at image specific filters like DCTDecode and leaves them to
+// the caller
+func (r *Reader) decodeStream(s *stream, image bool) ([]byte, error) {
+	data := s.data
+	filters, params := r.filters(s)
+	for i, filter := range filters {
+		var err error
+		switch filter {
+		case "FlateDecode", "Fl":
+			if data, err = inflate(data); err != nil {
+				return nil, err
+			}
+			if data, err = r.unpredict(data, params[i]); err != nil {
+				return nil, err
+			}
+		case "ASCII85Decode", "A85":
+			data = bytes.TrimSuffix(bytes.TrimSpace(data), []byte("~>"))
+			if data, err = readAll(ascii85.NewDecoder(bytes.NewReader(data))); err != nil {
+				return nil, err
+			}
+		case "ASCIIHexDecode", "AHx":
+			data = []byte(decodeHex(bytes.TrimSuffix(bytes.TrimSpace(data), []byte(">"))))
+		case "DCTDecode", "DCT", "CCITTFaxDecode", "CCF":
+			if image && i == len(filters)-1 {
+				return data, nil
+			}
+			return nil, fmt.Errorf("%w: %s", ErrUnsupported, filter)
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupported, filter)
+		}
+	}
+
+	return data, nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	out, err := readAll(zr)
+	// Plenty of pdfs have truncated streams, take what we can get
+	if err != nil && (len(out) == 0 || errors.Is(err, errTooBig)) {
+		return nil, err
+	}
+	return out, nil
+}
+
+var errTooBig = fmt.Errorf("%w: stream decodes to more than %d bytes", ErrUnsupported, maxDecoded)
+
+// readAll reads a decoder to the end, as long as it doesn't go past
+// maxDecoded
+func readAll(r io.Reader) ([]byte, error) {
+	out, err := ioutil.ReadAll(io.LimitReader(r, maxDecoded+1))
+	if len(out) > maxDecoded {
+		return nil, errTooBig
+	}
+	return out, err
+}
+
+// unpredict undoes the PNG predictors flate streams can use
+func (r *Reader) unpredict(data []byte, params dict) ([]byte, error) {
+	predictor := r.integer(params["Predictor"], 1)
+	if predictor == 1 {
+		return data, nil
+	}
+	if predictor < 10 {
+		return nil, fmt.Errorf("%w: predictor %d", ErrUnsupported, predictor)
+	}
+
+	colors := r.integer(params["Colors"], 1)
+	bpc := r.integer(params["BitsPerComponent"], 8)
+	columns := r.integer(params["Columns"], 1)
+	if colors < 1 || colors > 32 || columns < 1 || columns > maxDimension {
+		return nil, fmt.Errorf("%w: predictor with %d colours and %d columns", ErrUnsupported, colors, columns)
+	}
+	if err := checkDepth(bpc); err != nil {
+		return nil, err
+	}
+	bpp := (colors*bpc + 7) / 8
+	stride := (colors*bpc*columns + 7) / 8
+
+	out := make([]byte, 0, len(data))
+	prev := make([]byte, stride)
+	for len(data) >= stride+1 {
+		kind, row := data[0], append([]byte(nil), data[1:stride+1]...)
+		data = data[stride+1:]
+
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch kind {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+
+		out = append(out, row...)
+		prev = row
+	}
+
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (r *Reader) integer(obj interface{}, def int) int {
+	if v, isa := r.number(obj); isa {
+		return int(v)
+	}
+	return def
+}
+
+// colorSpace works out how many components a colour space has, and the
+// palette if it's indexed
+func (r *Reader) colorSpace(obj interface{}) (base string, components int, palette []byte, err error) {
+	switch cs := r.resolve(obj).(type) {
+	case name:
+		switch cs {
+		case "DeviceGray", "G", "CalGray":
+			return "DeviceGray", 1, nil, nil
+		case "DeviceRGB", "RGB", "CalRGB":
+			return "DeviceRGB", 3, nil, nil
+		case "DeviceCMYK", "CMYK":
+			return "DeviceCMYK", 4, nil, nil
+		}
+	case array:
+		if len(cs) == 0 {
+			break
+		}
+		switch kind, _ := r.resolve(cs[0]).(name); kind {
+		case "ICCBased":
+			if len(cs) < 2 {
+				break
+			}
+			if s, isa := r.resolve(cs[1]).(*stream); isa {
+				switch r.integer(s.dict["N"], 3) {
+				case 1:
+					return "DeviceGray", 1, nil, nil
+				case 4:
+					return "DeviceCMYK", 4, nil, nil
+				}
+				return "DeviceRGB", 3, nil, nil
+			}
+		case "CalGray", "CalRGB":
+			return r.colorSpace(kind)
+		case "Indexed", "I":
+			if len(cs) < 4 {
+				break
+			}
+			base, components, _, err := r.colorSpace(cs[1])
+			if err != nil || components == 4 {
+				break
+			}
+			switch lookup := r.resolve(cs[3]).(type) {
+			case str:
+				return base, components, []byte(lookup), nil
+			case *stream:
+				data, err := r.decodeStream(lookup, false)
+				return base, components, data, err
+			}
+		}
+	}
+
+	return "", 0, nil, fmt.Errorf("%w: colour space", ErrUnsupported)
+}
+
+// Decode turns the page's image into pixels
+func (p *Page) Decode() (image.Image, error) {
+	if p.image == nil {
+		return nil, errors.New("page has no image")
+	}
+
+	r, s := p.r, p.image
+	data, err := r.decodeStream(s, true)
+	if err != nil {
+		return nil, err
+	}
+
+	width := r.integer(s.dict["Width"], 0)
+	height := r.integer(s.dict["Height"], 0)
+	if err := checkSize(width, height); err != nil {
+		return nil, err
+	}
+
+	filters, params := r.filters(s)
+	last := name("")
+	if len(filters) > 0 {
+		last = filters[len(filters)-1]
+	}
+
+	switch last {
+	case "DCTDecode", "DCT":
+		// The jpeg says how big it is, which needn't agree with the pdf
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSize(config.Width, config.Height); err != nil {
+			return nil, err
+		}
+		return jpeg.Decode(bytes.NewReader(data))
+	case "CCITTFaxDecode", "CCF":
+		return r.decodeCCITT(data, params[len(params)-1], height)
+	}
+
+	if r.resolve(s.dict["ImageMask"]) == true {
+		return decodeGray(data, width, height, 1, true)
+	}
+
+	bpc := r.integer(s.dict["BitsPerComponent"], 8)
+	if err := checkDepth(bpc); err != nil {
+		return nil, err
+	}
+	_, components, palette, err := r.colorSpace(s.dict["ColorSpace"])
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case palette != nil:
+		return decodeIndexed(data, width, height, bpc, components, palette)
+	case components == 1:
+		return decodeGray(data, width, height, bpc, false)
+	case bpc != 8:
+		return nil, fmt.Errorf("%w: %d bits per component", ErrUnsupported, bpc)
+	case components == 3:
+		if len(data) < width*height*3 {
+			return nil, errors.New("image data is truncated")
+		}
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for i, j := 0, 0; i < width*height; i, j = i+1, j+3 {
+			img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = data[j], data[j+1], data[j+2], 0xff
+		}
+		return img, nil
+	case components == 4:
+		if len(data) < width*height*4 {
+			return nil, errors.New("image data is truncated")
+		}
+		img := image.NewCMYK(image.Rect(0, 0, width, height))
+		copy(img.Pix, data)
+		return img, nil
+	}
+
+	return nil, fmt.Errorf("%w: %d components", ErrUnsupported, components)
+}
+
+func (r *Reader) decodeCCITT(data []byte, params dict, height int) (image.Image, error) {
+	k := r.integer(params["K"], 0)
+	if k > 0 {
+		return nil, fmt.Errorf("%w: mixed CCITT group 3", ErrUnsupported)
+	}
+
+	sf := ccitt.Group3
+	if k < 0 {
+		sf = ccitt.Group4
+	}
+
+	columns := r.integer(params["Columns"], 1728)
+	rows := r.integer(params["Rows"], height)
+	if err := checkSize(columns, rows); err != nil {
+		return nil, err
+	}
+
+	img := image.NewGray(image.Rect(0, 0, columns, rows))
+	err := ccitt.DecodeIntoGray(img, bytes.NewReader(data), ccitt.MSB, sf, &ccitt.Options{
+		Align:  r.resolve(params["EncodedByteAlign"]) == true,
+		Invert: r.resolve(params["BlackIs1"]) == true,
+	})
+	return img, err
+}
+
+// decodeGray unpacks grey pixels of any depth, masks are 1 bit with 1
+// meaning paint black
+func decodeGray(data []byte, width, height, bpc int, mask bool) (image.Image, error) {
+	stride := (width*bpc + 7) / 8
+	if len(data) < stride*height {
+		return nil, errors.New("image data is truncated")
+	}
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	maxValue := (1 << uint(bpc)) - 1
+	for y := 0; y < height; y++ {
+		row := data[y*stride : (y+1)*stride]
+		for x := 0; x < width; x++ {
+			v := sample(row, x, bpc)
+			if mask {
+				v = maxValue - v
+			}
+			img.Pix[y*img.Stride+x] = uint8(v * 255 / maxValue)
+		}
+	}
+
+	return img, nil
+}
+
+func decodeIndexed(data []byte, width, height, bpc, components int, palette []byte) (image.Image, error) {
+	stride := (width*bpc + 7) / 8
+	if len(data) < stride*height {
+		return nil, errors.New("image data is truncated")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		row := data[y*stride : (y+1)*stride]
+		for x := 0; x < width; x++ {
+			i := sample(row, x, bpc) * components
+			var c color.RGBA
+			switch {
+			case i+components > len(palette):
+			case components == 1:
+				c = color.RGBA{palette[i], palette[i], palette[i], 0xff}
+			default:
+				c = color.RGBA{palette[i], palette[i+1], palette[i+2], 0xff}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	return img, nil
+}
+
+// sample reads the x'th value of bpc bits from a row
+func sample(row []byte, x, bpc int) int {
+	switch bpc {
+	case 8:
+		return int(row[x])
+	case 16:
+		return int(row[x*2])<<8 | int(row[x*2+1])
+	}
+
+	bit := x * bpc
+	return int(row[bit/8]>>(8-uint(bpc)-uint(bit%8))) & (1<<uint(bpc) - 1)
+}
+
+// Image returns the page's image ready to be written to another PDF, it's
+// copied as is where possible and recompressed otherwise
+func (p *Page) Image() (*Image, error) {
+	if p.image == nil {
+		return nil, errors.New("page has no image")
+	}
+
+	r, s := p.r, p.image
+	width := r.integer(s.dict["Width"], 0)
+	height := r.integer(s.dict["Height"], 0)
+	dpi := DefaultDPI * 1.0
+	if p.Width > 0 {
+		dpi = float64(width) * 72 / p.Width
+	}
+
+	filters, params := r.filters(s)
+	base, _, palette, csErr := r.colorSpace(s.dict["ColorSpace"])
+	bpc := r.integer(s.dict["BitsPerComponent"], 8)
+
+	if len(filters) == 1 && csErr == nil && palette == nil && r.resolve(s.dict["Decode"]) == nil {
+		switch filters[0] {
+		case "DCTDecode", "DCT":
+			return &Image{Width: width, Height: height, DPI: dpi, ColorSpace: base, Filter: "DCTDecode", Data: s.data}, nil
+		case "FlateDecode", "Fl":
+			if params[0] == nil && bpc == 8 {
+				return &Image{Width: width, Height: height, DPI: dpi, ColorSpace: base, Filter: "FlateDecode", Data: s.data}, nil
+			}
+		}
+	}
+
+	if len(filters) == 1 && (filters[0] == "CCITTFaxDecode" || filters[0] == "CCF") && r.resolve(s.dict["ImageMask"]) != true {
+		ps := params[0]
+		return &Image{
+			Width:            width,
+			Height:           height,
+			DPI:              dpi,
+			ColorSpace:       "DeviceGray",
+			Filter:           "CCITTFaxDecode",
+			BitsPerComponent: 1,
+			DecodeParms: fmt.Sprintf("<< /K %d /Columns %d /Rows %d /BlackIs1 %t /EncodedByteAlign %t >>",
+				r.integer(ps["K"], 0), r.integer(ps["Columns"], 1728), r.integer(ps["Rows"], height),
+				r.resolve(ps["BlackIs1"]) == true, r.resolve(ps["EncodedByteAlign"]) == true),
+			Data: s.data,
+		}, nil
+	}
+
+	img, err := p.Decode()
+	if err != nil {
+		return nil, err
+	}
+	return Encode(img, dpi)
+}
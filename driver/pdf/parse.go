@@ -0,0 +1,312 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// The handful of PDF object types, numbers are int64 or float64, booleans
+// are bool and null is nil
+type (
+	name   string
+	dict   map[name]interface{}
+	array  []interface{}
+	str    string
+	ref    struct{ id, gen int64 }
+	stream struct {
+		dict dict
+		data []byte
+	}
+)
+
+var errSyntax = errors.New("pdf syntax error")
+
+// maxNesting is how deep arrays and dictionaries may be nested, real
+// documents don't come close
+const maxNesting = 256
+
+func isSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skip moves past white space and comments
+func skip(data []byte, pos int) int {
+	for pos < len(data) {
+		if isSpace(data[pos]) {
+			pos++
+			continue
+		}
+		if data[pos] == '%' {
+			for pos < len(data) && data[pos] != '\n' && data[pos] != '\r' {
+				pos++
+			}
+			continue
+		}
+		break
+	}
+	return pos
+}
+
+// token reads a regular token such as a number or keyword
+func token(data []byte, pos int) (string, int) {
+	start := pos
+	for pos < len(data) && !isSpace(data[pos]) && !isDelimiter(data[pos]) {
+		pos++
+	}
+	return string(data[start:pos]), pos
+}
+
+// parse reads the object at pos, resolving stream lengths with length
+func parse(data []byte, pos int, length func(interface{}) (int64, bool)) (interface{}, int, error) {
+	return parseNested(data, pos, length, 0)
+}
+
+func parseNested(data []byte, pos int, length func(interface{}) (int64, bool), depth int) (interface{}, int, error) {
+	if depth > maxNesting {
+		return nil, pos, fmt.Errorf("%w: nested too deeply", errSyntax)
+	}
+
+	pos = skip(data, pos)
+	if pos >= len(data) {
+		return nil, pos, errSyntax
+	}
+
+	switch c := data[pos]; {
+	case c == '/':
+		tok, end := token(data, pos+1)
+		return name(unescapeName(tok)), end, nil
+
+	case c == '[':
+		var arr array
+		pos++
+		for {
+			pos = skip(data, pos)
+			if pos >= len(data) {
+				return nil, pos, errSyntax
+			}
+			if data[pos] == ']' {
+				return arr, pos + 1, nil
+			}
+			obj, end, err := parseNested(data, pos, length, depth+1)
+			if err != nil {
+				return nil, end, err
+			}
+			arr = append(arr, obj)
+			pos = end
+		}
+
+	case c == '<' && pos+1 < len(data) && data[pos+1] == '<':
+		d := make(dict)
+		pos += 2
+		for {
+			pos = skip(data, pos)
+			if pos+1 >= len(data) {
+				return nil, pos, errSyntax
+			}
+			if data[pos] == '>' && data[pos+1] == '>' {
+				pos += 2
+				break
+			}
+			key, end, err := parseNested(data, pos, length, depth+1)
+			if err != nil {
+				return nil, end, err
+			}
+			k, isa := key.(name)
+			if !isa {
+				return nil, end, errSyntax
+			}
+			value, end, err := parseNested(data, end, length, depth+1)
+			if err != nil {
+				return nil, end, err
+			}
+			d[k] = value
+			pos = end
+		}
+
+		// A dictionary followed by stream is the stream's dictionary
+		next := skip(data, pos)
+		if !bytes.HasPrefix(data[next:], []byte("stream")) {
+			return d, pos, nil
+		}
+		start := next + len("stream")
+		if start < len(data) && data[start] == '\r' {
+			start++
+		}
+		if start < len(data) && data[start] == '\n' {
+			start++
+		}
+
+		n, isa := length(d[name("Length")])
+		if !isa || n < 0 || n > int64(len(data)-start) || !bytes.HasPrefix(bytes.TrimLeft(data[start+int(n):], "\r\n \t"), []byte("endstream")) {
+			// Lengths are sometimes wrong so fall back to looking for the end
+			end := bytes.Index(data[start:], []byte("endstream"))
+			if end < 0 {
+				return nil, start, errSyntax
+			}
+			n = int64(len(bytes.TrimRight(data[start:start+end], "\r\n")))
+		}
+		return &stream{dict: d, data: data[start : start+int(n)]}, start + int(n), nil
+
+	case c == '<':
+		end := bytes.IndexByte(data[pos:], '>')
+		if end < 0 {
+			return nil, pos, errSyntax
+		}
+		return str(decodeHex(data[pos+1 : pos+end])), pos + end + 1, nil
+
+	case c == '(':
+		s, end, err := literal(data, pos)
+		return str(s), end, err
+
+	case isDelimiter(c):
+		return nil, pos, errSyntax
+	}
+
+	tok, end := token(data, pos)
+	switch tok {
+	case "true":
+		return true, end, nil
+	case "false":
+		return false, end, nil
+	case "null":
+		return nil, end, nil
+	}
+
+	if i, err := strconv.ParseInt(tok, 10, 64); err == nil {
+		// Check for an indirect reference, "id gen R"
+		p := skip(data, end)
+		gen, p2 := token(data, p)
+		if g, err := strconv.ParseInt(gen, 10, 64); err == nil {
+			p3 := skip(data, p2)
+			if r, p4 := token(data, p3); r == "R" {
+				return ref{id: i, gen: g}, p4, nil
+			}
+		}
+		return i, end, nil
+	}
+
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return f, end, nil
+	}
+
+	return nil, end, fmt.Errorf("%w: unexpected %q", errSyntax, tok)
+}
+
+func unescapeName(s string) string {
+	if !bytes.ContainsRune([]byte(s), '#') {
+		return s
+	}
+
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b = append(b, byte(v))
+				i += 2
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+func decodeHex(data []byte) string {
+	var b []byte
+	var hi byte
+	odd := false
+	for _, c := range data {
+		var v byte
+		switch {
+		case c >= '0' && c <= '9':
+			v = c - '0'
+		case c >= 'a' && c <= 'f':
+			v = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			v = c - 'A' + 10
+		default:
+			continue
+		}
+		if odd {
+			b = append(b, hi<<4|v)
+		} else {
+			hi = v
+		}
+		odd = !odd
+	}
+	if odd {
+		b = append(b, hi<<4)
+	}
+	return string(b)
+}
+
+// literal reads a (string), only the escapes that matter for lookup tables
+// are handled
+func literal(data []byte, pos int) (string, int, error) {
+	var b []byte
+	depth := 0
+	for pos++; pos < len(data); pos++ {
+		c := data[pos]
+		switch c {
+		case '\\':
+			pos++
+			if pos >= len(data) {
+				return "", pos, errSyntax
+			}
+			switch e := data[pos]; e {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case 'b':
+				b = append(b, '\b')
+			case 'f':
+				b = append(b, '\f')
+			case '\r', '\n':
+				if e == '\r' && pos+1 < len(data) && data[pos+1] == '\n' {
+					pos++
+				}
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for n := 0; n < 3 && pos < len(data) && data[pos] >= '0' && data[pos] <= '7'; n++ {
+						v = v*8 + int(data[pos]-'0')
+						pos++
+					}
+					pos--
+					b = append(b, byte(v))
+				} else {
+					b = append(b, e)
+				}
+			}
+		case '(':
+			depth++
+			b = append(b, c)
+		case ')':
+			if depth == 0 {
+				return string(b), pos + 1, nil
+			}
+			depth--
+			b = append(b, c)
+		default:
+			b = append(b, c)
+		}
+	}
+	return "", pos, errSyntax
+}
@@ -0,0 +1,351 @@
+package pdf
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+// Reader finds the pages of a PDF and the image on each of them. It only
+// understands as much of the format as scanned documents need
+type Reader struct {
+	data    []byte
+	offsets map[int64]int
+	objects map[int64]interface{}
+	packed  map[int64]packedObject
+	trailer dict
+}
+
+// packedObject is an object stored inside an object stream
+type packedObject struct {
+	stream int64
+	index  int
+}
+
+var objectHeader = regexp.MustCompile(`(?m)(?:^|[\r\n\s])(\d+)\s+(\d+)\s+obj\b`)
+
+// NewReader indexes the objects in data, later definitions win like they
+// do for incremental updates
+func NewReader(data []byte) (*Reader, error) {
+	r := &Reader{
+		data:    data,
+		offsets: make(map[int64]int),
+		objects: make(map[int64]interface{}),
+	}
+
+	for _, m := range objectHeader.FindAllSubmatchIndex(data, -1) {
+		id, err := strconv.ParseInt(string(data[m[2]:m[3]]), 10, 64)
+		if err != nil {
+			continue
+		}
+		r.offsets[id] = m[1]
+	}
+
+	if len(r.offsets) == 0 {
+		return nil, errors.New("not a pdf")
+	}
+
+	r.trailer = r.findTrailer()
+	if r.trailer == nil {
+		return nil, errors.New("pdf has no trailer")
+	}
+	if _, isa := r.trailer["Encrypt"]; isa {
+		return nil, errors.New("encrypted pdfs are not supported")
+	}
+
+	return r, nil
+}
+
+// findTrailer uses the last trailer dictionary or cross reference stream
+func (r *Reader) findTrailer() dict {
+	trailers := regexp.MustCompile(`trailer\s*<<`).FindAllIndex(r.data, -1)
+	for i := len(trailers) - 1; i >= 0; i-- {
+		obj, _, err := parse(r.data, trailers[i][1]-2, r.length)
+		if d, isa := obj.(dict); err == nil && isa {
+			if _, isa := d["Root"]; isa {
+				return d
+			}
+		}
+	}
+
+	var found dict
+	var foundAt int
+	for id, offset := range r.offsets {
+		if s, isa := r.object(id).(*stream); isa && s.dict["Type"] == name("XRef") && offset > foundAt {
+			found, foundAt = s.dict, offset
+		}
+	}
+	return found
+}
+
+func (r *Reader) length(obj interface{}) (int64, bool) {
+	i, isa := r.resolve(obj).(int64)
+	return i, isa
+}
+
+// object loads an object by number
+func (r *Reader) object(id int64) interface{} {
+	if obj, isa := r.objects[id]; isa {
+		return obj
+	}
+	// Guards against loops through stream lengths
+	r.objects[id] = nil
+
+	var obj interface{}
+	if offset, isa := r.offsets[id]; isa {
+		obj, _, _ = parse(r.data, offset, r.length)
+	} else {
+		obj = r.unpack(id)
+	}
+
+	r.objects[id] = obj
+	return obj
+}
+
+// unpack finds an object inside an object stream
+func (r *Reader) unpack(id int64) interface{} {
+	if r.packed == nil {
+		r.packed = make(map[int64]packedObject)
+		for sid := range r.offsets {
+			s, isa := r.object(sid).(*stream)
+			if !isa || s.dict["Type"] != name("ObjStm") {
+				continue
+			}
+			data, err := r.decodeStream(s, false)
+			if err != nil {
+				continue
+			}
+			n, _ := r.resolve(s.dict["N"]).(int64)
+			pos := 0
+			for i := 0; i < int(n); i++ {
+				obj, end, err := parse(data, pos, r.length)
+				if err != nil {
+					break
+				}
+				_, end, err = parse(data, end, r.length)
+				if err != nil {
+					break
+				}
+				if num, isa := obj.(int64); isa {
+					r.packed[num] = packedObject{stream: sid, index: i}
+				}
+				pos = end
+			}
+		}
+	}
+
+	p, isa := r.packed[id]
+	if !isa {
+		return nil
+	}
+
+	s, isa := r.object(p.stream).(*stream)
+	if !isa {
+		return nil
+	}
+	data, err := r.decodeStream(s, false)
+	if err != nil {
+		return nil
+	}
+
+	first, _ := r.resolve(s.dict["First"]).(int64)
+	pos := 0
+	for i := 0; i <= p.index; i++ {
+		var num, offset interface{}
+		if num, pos, err = parse(data, pos, r.length); err != nil {
+			return nil
+		}
+		if offset, pos, err = parse(data, pos, r.length); err != nil {
+			return nil
+		}
+		if i == p.index {
+			o, _ := offset.(int64)
+			if n, _ := num.(int64); n != id || first < 0 || o < 0 || first >= int64(len(data)) || o >= int64(len(data))-first {
+				return nil
+			}
+			obj, _, _ := parse(data, int(first+o), r.length)
+			return obj
+		}
+	}
+
+	return nil
+}
+
+// resolve follows indirect references
+func (r *Reader) resolve(obj interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, isa := obj.(ref)
+		if !isa {
+			return obj
+		}
+		obj = r.object(ref.id)
+	}
+	return nil
+}
+
+func (r *Reader) dict(obj interface{}) dict {
+	switch v := r.resolve(obj).(type) {
+	case dict:
+		return v
+	case *stream:
+		return v.dict
+	}
+	return nil
+}
+
+func (r *Reader) number(obj interface{}) (float64, bool) {
+	switch v := r.resolve(obj).(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Page is a page of a scanned document
+type Page struct {
+	// Width and Height of the page in points
+	Width, Height float64
+
+	r     *Reader
+	image *stream
+	// dict is the page itself and inherited what it takes from the page
+	// tree, they're what's needed to copy it
+	dict      dict
+	inherited dict
+}
+
+// inheritable are the page attributes that can be set further up the page
+// tree instead of on the page
+var inheritable = []name{"Resources", "MediaBox", "CropBox", "Rotate"}
+
+// Pages walks the page tree in order
+func (r *Reader) Pages() ([]*Page, error) {
+	root := r.dict(r.trailer["Root"])
+	if root == nil {
+		return nil, errors.New("pdf has no catalog")
+	}
+
+	var pages []*Page
+	// Kids pointing back up the tree would have us walk it forever
+	seen := make(map[int64]bool)
+	var walk func(node dict, inherited dict, depth int) error
+	walk = func(node dict, inherited dict, depth int) error {
+		if depth > 64 {
+			return errors.New("pdf page tree is too deep")
+		}
+
+		if node != nil {
+			parent := inherited
+			inherited = make(dict, len(inheritable))
+			for _, key := range inheritable {
+				if v, isa := node[key]; isa {
+					inherited[key] = v
+				} else if v, isa := parent[key]; isa {
+					inherited[key] = v
+				}
+			}
+		}
+		resources := r.dict(inherited["Resources"])
+		mediaBox, _ := r.resolve(inherited["MediaBox"]).(array)
+		if len(mediaBox) != 4 {
+			mediaBox = nil
+		}
+
+		if node["Type"] == name("Page") || node["Kids"] == nil {
+			page := &Page{r: r, Width: 612, Height: 792, dict: node, inherited: inherited}
+			if mediaBox != nil {
+				x0, _ := r.number(mediaBox[0])
+				y0, _ := r.number(mediaBox[1])
+				x1, _ := r.number(mediaBox[2])
+				y1, _ := r.number(mediaBox[3])
+				page.Width, page.Height = x1-x0, y1-y0
+				if page.Width < 0 {
+					page.Width = -page.Width
+				}
+				if page.Height < 0 {
+					page.Height = -page.Height
+				}
+			}
+			page.image = r.largestImage(resources, 0, make(map[*stream]bool))
+			pages = append(pages, page)
+			return nil
+		}
+
+		kids, _ := r.resolve(node["Kids"]).(array)
+		for _, kid := range kids {
+			if ref, isa := kid.(ref); isa {
+				if seen[ref.id] {
+					return errors.New("pdf page tree has a loop")
+				}
+				seen[ref.id] = true
+			}
+			if child := r.dict(kid); child != nil {
+				if err := walk(child, inherited, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if ref, isa := root["Pages"].(ref); isa {
+		seen[ref.id] = true
+	}
+	if err := walk(r.dict(root["Pages"]), nil, 0); err != nil {
+		return nil, err
+	}
+
+	if len(pages) == 0 {
+		return nil, errors.New("pdf has no pages")
+	}
+
+	return pages, nil
+}
+
+// largestImage finds the biggest image a page draws, looking inside forms
+// since some scanners wrap their images in one. Each form is only looked in
+// once however many times it's used
+func (r *Reader) largestImage(resources dict, depth int, seen map[*stream]bool) *stream {
+	if resources == nil || depth > 4 {
+		return nil
+	}
+
+	var largest *stream
+	var largestSize float64
+	for _, obj := range r.dict(resources["XObject"]) {
+		s, isa := r.resolve(obj).(*stream)
+		if !isa {
+			continue
+		}
+
+		switch s.dict["Subtype"] {
+		case name("Image"):
+			w, _ := r.number(s.dict["Width"])
+			h, _ := r.number(s.dict["Height"])
+			if w*h > largestSize {
+				largest, largestSize = s, w*h
+			}
+		case name("Form"):
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+			if img := r.largestImage(r.dict(s.dict["Resources"]), depth+1, seen); img != nil {
+				w, _ := r.number(img.dict["Width"])
+				h, _ := r.number(img.dict["Height"])
+				if w*h > largestSize {
+					largest, largestSize = img, w*h
+				}
+			}
+		}
+	}
+
+	return largest
+}
+
+// HasImage reports whether the page has an image on it at all
+func (p *Page) HasImage() bool {
+	return p.image != nil
+}
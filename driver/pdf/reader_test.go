@@ -0,0 +1,336 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+// handmade builds a pdf out of the given objects, numbered from 1, with the
+// first being the catalog. Offsets aren't needed, the reader finds objects
+// by looking for them
+func handmade(objects ...string) []byte {
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return []byte(b.String())
+}
+
+// onePage is a catalog, page tree and page drawing the image in object 4
+func onePage(image string) []byte {
+	return handmade(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 72 72] /Resources << /XObject << /Im0 4 0 R >> >> >>",
+		image,
+	)
+}
+
+// read does everything the processors and drivers do with a pdf, failing
+// the test if anything panics
+func read(t *testing.T, data []byte) (pages int, err error) {
+	t.Helper()
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("panic reading %q: %v\n%s", truncate(data), r, debug.Stack())
+		}
+	}()
+
+	r, err := NewReader(data)
+	if err != nil {
+		return 0, err
+	}
+	all, err := r.Pages()
+	if err != nil {
+		return 0, err
+	}
+	for _, page := range all {
+		if !page.HasImage() {
+			continue
+		}
+		if _, err = page.Decode(); err != nil {
+			continue
+		}
+		if _, err = page.Image(); err != nil {
+			continue
+		}
+	}
+	return len(all), err
+}
+
+func truncate(data []byte) string {
+	if len(data) > 400 {
+		return string(data[:400]) + "..."
+	}
+	return string(data)
+}
+
+func TestMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"huge length":                     onePage("<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 9223372036854775807 >>\nstream\n\x00\nendstream"),
+		"negative length":                 onePage("<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length -5 >>\nstream\n\x00\nendstream"),
+		"length past the end":             []byte("%PDF-1.4\n1 0 obj\n<< /Length 100 >>\nstream\nabc"),
+		"negative columns":                onePage("<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns -8 >> /Length 9 >>\nstream\nx\x9cc\x00\x00\x00\x01\x00\x01\nendstream"),
+		"huge columns":                    onePage("<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 4611686018427387904 /Colors 4 >> /Length 9 >>\nstream\nx\x9cc\x00\x00\x00\x01\x00\x01\nendstream"),
+		"zero bits per component":         onePage("<< /Type /XObject /Subtype /Image /Width 4 /Height 4 /ColorSpace /DeviceGray /BitsPerComponent 0 /Length 16 >>\nstream\n0123456789abcdef\nendstream"),
+		"odd bits per component":          onePage("<< /Type /XObject /Subtype /Image /Width 4 /Height 4 /ColorSpace /DeviceGray /BitsPerComponent 7 /Length 16 >>\nstream\n0123456789abcdef\nendstream"),
+		"zero bits per component indexed": onePage("<< /Type /XObject /Subtype /Image /Width 4 /Height 4 /ColorSpace [/Indexed /DeviceRGB 1 <000000ffffff>] /BitsPerComponent 0 /Length 16 >>\nstream\n0123456789abcdef\nendstream"),
+		"huge image":                      onePage("<< /Type /XObject /Subtype /Image /Width 2000000000 /Height 2000000000 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 1 >>\nstream\n\x00\nendstream"),
+		"huge fax":                        onePage("<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 1 /Filter /CCITTFaxDecode /DecodeParms << /K -1 /Columns 2000000000 /Rows 2000000000 >> /Length 1 >>\nstream\n\x00\nendstream"),
+		"negative fax":                    onePage("<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 1 /Filter /CCITTFaxDecode /DecodeParms << /K -1 /Columns -1 /Rows -1 >> /Length 1 >>\nstream\n\x00\nendstream"),
+		"ascii85 zeros":                   onePage("<< /Type /XObject /Subtype /Image /Width 8 /Height 8 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /ASCII85Decode /Length 18 >>\nstream\nzzzzzzzzzzzzzzzz~>\nendstream"),
+		"negative objstm first": handmade(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /Resources 10 0 R >>",
+			"<< /Type /ObjStm /N 1 /First -100 /Length 10 >>\nstream\n10 0 << >>\nendstream",
+		),
+		"objstm offset past the end": handmade(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /Resources 10 0 R >>",
+			"<< /Type /ObjStm /N 1 /First 9223372036854775807 /Length 10 >>\nstream\n10 7 << >>\nendstream",
+		),
+		"page tree loop": handmade(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R 2 0 R] /Count 1 >>",
+			"<< /Type /Pages /Kids [2 0 R 3 0 R] >>",
+		),
+		"form loop":      onePage("<< /Type /XObject /Subtype /Form /Resources << /XObject << /A 4 0 R /B 4 0 R >> >> /Length 0 >>\nstream\n\nendstream"),
+		"deep nesting":   handmade("<< /Type /Catalog /Pages " + strings.Repeat("[", 100000) + " >>"),
+		"reference loop": handmade("<< /Type /Catalog /Pages 2 0 R >>", "3 0 R", "2 0 R"),
+		"length loop":    handmade("<< /Type /Catalog /Pages 2 0 R >>", "<< /Length 2 0 R >>\nstream\nabc\nendstream"),
+		"unterminated":   []byte("%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages [ (abc\\"),
+		"empty":          nil,
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			read(t, data)
+		})
+	}
+}
+
+// valid makes a few pdfs in the ways scanners do
+func valid(t *testing.T) [][]byte {
+	t.Helper()
+
+	gray := image.NewGray(image.Rect(0, 0, 16, 8))
+	rgb := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 7)
+	}
+	for i := range rgb.Pix {
+		rgb.Pix[i] = uint8(i * 13)
+	}
+
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, rgb, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var docs [][]byte
+	for _, imgs := range [][]image.Image{{gray}, {rgb, gray}, {nil}} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		for _, img := range imgs {
+			var enc *Image
+			var err error
+			if img == nil {
+				enc, err = JPEG(jpg.Bytes())
+			} else {
+				enc, err = Encode(img, 150)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.AddPage(enc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, buf.Bytes())
+	}
+
+	// Predictors, palettes and object streams don't come out of the writer
+	docs = append(docs,
+		onePage("<< /Type /XObject /Subtype /Image /Width 2 /Height 2 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 2 >> /Length 17 >>\nstream\n"+deflate([]byte{1, 1, 2, 2, 3, 4})+"\nendstream"),
+		onePage("<< /Type /XObject /Subtype /Image /Width 4 /Height 2 /ColorSpace [/Indexed /DeviceRGB 1 <000000ffffff>] /BitsPerComponent 1 /Length 2 >>\nstream\n\xa0\x50\nendstream"),
+		handmade(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /Resources 10 0 R >>",
+			"<< /Type /ObjStm /N 2 /First 11 /Length 38 >>\nstream\n10 0 11 22 << /XObject 11 0 R >> << >>\nendstream",
+		),
+	)
+
+	return docs
+}
+
+func deflate(data []byte) string {
+	img := &image.Gray{Pix: data, Stride: len(data), Rect: image.Rect(0, 0, len(data), 1)}
+	enc, _ := Encode(img, DefaultDPI)
+	return string(enc.Data)
+}
+
+func TestValid(t *testing.T) {
+	for i, data := range valid(t) {
+		if pages, err := read(t, data); err != nil || pages == 0 {
+			t.Errorf("pdf %d: read %d pages, %v", i, pages, err)
+		}
+	}
+}
+
+var numbers = regexp.MustCompile(`-?\d+`)
+
+// TestMutations reads damaged copies of valid pdfs, they can fail to read
+// but mustn't panic
+func TestMutations(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	evil := []string{"0", "-1", "1", "7", "65536", "2147483648", "-9223372036854775808", "9223372036854775807", "1e300"}
+
+	for n, data := range valid(t) {
+		for i := 0; i < 1500; i++ {
+			mutated := append([]byte(nil), data...)
+			switch rnd.Intn(4) {
+			case 0:
+				// Swap one of the numbers for something awkward
+				locs := numbers.FindAllIndex(mutated, -1)
+				loc := locs[rnd.Intn(len(locs))]
+				mutated = append(append(append([]byte(nil), mutated[:loc[0]]...), evil[rnd.Intn(len(evil))]...), mutated[loc[1]:]...)
+			case 1:
+				for j := rnd.Intn(8) + 1; j > 0; j-- {
+					mutated[rnd.Intn(len(mutated))] = byte(rnd.Intn(256))
+				}
+			case 2:
+				mutated = mutated[:rnd.Intn(len(mutated))]
+			case 3:
+				at := rnd.Intn(len(mutated))
+				mutated = append(mutated[:at], mutated[at+rnd.Intn(len(mutated)-at):]...)
+			}
+
+			t.Run(fmt.Sprintf("%d/%d", n, i), func(t *testing.T) {
+				read(t, mutated)
+			})
+		}
+	}
+}
+
+func TestDecodeIndexed(t *testing.T) {
+	data := valid(t)[4]
+	r, err := NewReader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := r.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := pages[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []uint8{255, 0, 255, 0, 0, 255, 0, 255}
+	for i, v := range want {
+		if c := color.GrayModel.Convert(img.At(i%4, i/4)).(color.Gray); c.Y != v {
+			t.Errorf("pixel %d is %d, expected %d", i, c.Y, v)
+		}
+	}
+}
+
+func TestPredictor(t *testing.T) {
+	r, err := NewReader(valid(t)[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := r.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := pages[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Row one is "sub" so 1, 1+2, row two is "up" so 1+3, 3+4
+	gray := img.(*image.Gray)
+	if want := []uint8{1, 3, 4, 7}; !bytes.Equal(gray.Pix, want) {
+		t.Errorf("decoded %v, expected %v", gray.Pix, want)
+	}
+}
+
+func TestObjectStream(t *testing.T) {
+	r, err := NewReader(valid(t)[5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := r.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].HasImage() {
+		t.Errorf("read %d pages", len(pages))
+	}
+	resources := r.dict(ref{id: 10})
+	if resources == nil || r.dict(resources["XObject"]) == nil {
+		t.Errorf("packed resources weren't found, got %v", resources)
+	}
+}
+
+func TestImageCoverage(t *testing.T) {
+	img, err := Encode(image.NewGray(image.Rect(0, 0, 10, 10)), 72)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		content string
+		want    float64
+	}{
+		{"q 100 0 0 100 0 0 cm /Im0 Do Q", 1},
+		{"q 10 0 0 10 5 85 cm /Im0 Do Q BT (hello) Tj ET", 0.01},
+		// Scaled in two steps and restored before the second one
+		{"q 2 0 0 2 0 0 cm q 25 0 0 50 0 0 cm /Im0 Do Q Q q 10 0 0 10 0 0 cm /Im0 Do Q", 0.5},
+		// Drawn through a form that scales it again
+		{"q 50 0 0 50 0 0 cm /Fm0 Do Q", 1},
+		{"BT (no image drawn) Tj ET", 0},
+	}
+
+	for _, test := range tests {
+		data := handmade(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 100 100] /Resources << /XObject << /Im0 5 0 R /Fm0 6 0 R >> >> /Contents 4 0 R >>",
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(test.content), test.content),
+			fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width 10 /Height 10 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", len(img.Data), img.Data),
+			"<< /Type /XObject /Subtype /Form /Matrix [2 0 0 2 0 0] /Resources << /XObject << /Im1 5 0 R >> >> /Length 25 >>\nstream\nq 1 0 0 1 0 0 cm /Im1 Do Q\nendstream",
+		)
+
+		r, err := NewReader(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, err := r.Pages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pages[0].ImageCoverage(); math.Abs(got-test.want) > 0.001 {
+			t.Errorf("%q covers %v, expected %v", test.content, got, test.want)
+		}
+	}
+}
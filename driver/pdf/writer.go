@@ -0,0 +1,435 @@
+// Package pdf reads and writes the image only PDFs scanners produce, one
+// image per page, without pulling in a full PDF library. Pages can also be
+// copied whole from one PDF to another for splitting documents up
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// DefaultDPI is used to size pages when an image doesn't say how big it is
+const DefaultDPI = 300
+
+// Image is an image ready to be placed on a page
+type Image struct {
+	Width, Height int
+	// DPI decides the size of the page the image is placed on
+	DPI float64
+	// ColorSpace is one of DeviceGray, DeviceRGB or DeviceCMYK
+	ColorSpace string
+	// Filter is how Data is encoded, DCTDecode for JPEG, FlateDecode for
+	// zlib compressed pixels or CCITTFaxDecode for fax encoded black and
+	// white which also needs DecodeParms
+	Filter      string
+	DecodeParms string
+	Data        []byte
+	// BitsPerComponent defaults to 8
+	BitsPerComponent int
+	// Invert is set for Adobe CMYK JPEGs which store their colours inverted
+	Invert bool
+}
+
+// JPEG wraps a JPEG as is, the DPI comes from the JFIF header if it has one
+func JPEG(data []byte) (*Image, error) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	img := &Image{
+		Width:  config.Width,
+		Height: config.Height,
+		DPI:    jfifDPI(data),
+		Filter: "DCTDecode",
+		Data:   data,
+	}
+
+	switch config.ColorModel {
+	case color.GrayModel:
+		img.ColorSpace = "DeviceGray"
+	case color.CMYKModel:
+		img.ColorSpace = "DeviceCMYK"
+		img.Invert = true
+	default:
+		img.ColorSpace = "DeviceRGB"
+	}
+
+	return img, nil
+}
+
+// jfifDPI reads the pixel density from a JFIF APP0 segment
+func jfifDPI(data []byte) float64 {
+	if len(data) < 18 || data[2] != 0xff || data[3] != 0xe0 || string(data[6:11]) != "JFIF\x00" {
+		return DefaultDPI
+	}
+
+	density := float64(int(data[14])<<8 | int(data[15]))
+	if density == 0 {
+		return DefaultDPI
+	}
+
+	switch data[13] {
+	case 1: // dots per inch
+		return density
+	case 2: // dots per cm
+		return density * 2.54
+	}
+
+	return DefaultDPI
+}
+
+// Encode compresses any other image losslessly, transparency is flattened
+// onto white since paper doesn't have any
+func Encode(src image.Image, dpi float64) (*Image, error) {
+	b := src.Bounds()
+	img := &Image{
+		Width:  b.Dx(),
+		Height: b.Dy(),
+		DPI:    dpi,
+		Filter: "FlateDecode",
+	}
+
+	var pixels []byte
+	switch src := src.(type) {
+	case *image.Gray:
+		img.ColorSpace = "DeviceGray"
+		pixels = make([]byte, 0, img.Width*img.Height)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := src.PixOffset(b.Min.X, y)
+			pixels = append(pixels, src.Pix[i:i+img.Width]...)
+		}
+	default:
+		img.ColorSpace = "DeviceRGB"
+		rgba := image.NewRGBA(image.Rect(0, 0, img.Width, img.Height))
+		draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Over)
+
+		pixels = make([]byte, 0, img.Width*img.Height*3)
+		for i := 0; i < len(rgba.Pix); i += 4 {
+			pixels = append(pixels, rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+		}
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(pixels); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	img.Data = buf.Bytes()
+
+	return img, nil
+}
+
+// Writer writes a PDF with one image per page, or pages copied from other
+// PDFs, Close must be called to finish it off
+type Writer struct {
+	w       *bufio.Writer
+	n       int64
+	offsets []int64
+	pages   []int
+	err     error
+
+	// copied maps the objects of each pdf pages were copied from to the
+	// objects they became, so what pages share is only written once
+	copied map[*Reader]map[int64]int
+	queue  []copyJob
+}
+
+// copyJob is an object that's been numbered but not written yet
+type copyJob struct {
+	r  *Reader
+	id int64
+	to int
+}
+
+// newRef refers to an object in the pdf being written
+type newRef int
+
+// Object numbers 1 and 2 are always the catalog and page tree so pages can
+// refer to their parent before it's written
+const (
+	catalogObject = 1
+	pagesObject   = 2
+)
+
+func NewWriter(w io.Writer) *Writer {
+	pw := &Writer{w: bufio.NewWriter(w), offsets: make([]int64, pagesObject)}
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	return pw
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+}
+
+// object starts the next object, or a reserved one if id is given
+func (w *Writer) object(id int) int {
+	if id == 0 {
+		w.offsets = append(w.offsets, w.n)
+		id = len(w.offsets)
+	} else {
+		w.offsets[id-1] = w.n
+	}
+	w.printf("%d 0 obj\n", id)
+	return id
+}
+
+// AddPage adds a page sized to fit the image at its DPI
+func (w *Writer) AddPage(img *Image) error {
+	if img.Width <= 0 || img.Height <= 0 {
+		return errors.New("image has no size")
+	}
+
+	dpi := img.DPI
+	if dpi <= 0 {
+		dpi = DefaultDPI
+	}
+	width := float64(img.Width) * 72 / dpi
+	height := float64(img.Height) * 72 / dpi
+
+	bpc := img.BitsPerComponent
+	if bpc == 0 {
+		bpc = 8
+	}
+
+	imageID := w.object(0)
+	w.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent %d /Filter /%s /Length %d",
+		img.Width, img.Height, img.ColorSpace, bpc, img.Filter, len(img.Data))
+	if img.DecodeParms != "" {
+		w.printf(" /DecodeParms %s", img.DecodeParms)
+	}
+	if img.Invert {
+		w.printf(" /Decode [1 0 1 0 1 0 1 0]")
+	}
+	w.printf(" >>\nstream\n")
+	w.write(img.Data)
+	w.printf("\nendstream\nendobj\n")
+
+	content := fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm /Im0 Do Q", width, height)
+	contentID := w.object(0)
+	w.printf("<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(content), content)
+
+	pageID := w.object(0)
+	w.printf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>\nendobj\n",
+		pagesObject, width, height, imageID, contentID)
+	w.pages = append(w.pages, pageID)
+
+	return w.err
+}
+
+// reserve numbers an object to be written later
+func (w *Writer) reserve() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+// CopyPage copies a page from another pdf as it is, along with everything
+// it uses, so text layers and vector content survive. Annotations are left
+// behind since they can point at pages that aren't being copied
+func (w *Writer) CopyPage(p *Page) error {
+	if p.dict == nil {
+		return errors.New("page can't be copied")
+	}
+
+	if w.copied == nil {
+		w.copied = make(map[*Reader]map[int64]int)
+	}
+	if w.copied[p.r] == nil {
+		w.copied[p.r] = make(map[int64]int)
+	}
+
+	page := make(dict, len(p.dict)+len(p.inherited))
+	for k, v := range p.inherited {
+		page[k] = v
+	}
+	for k, v := range p.dict {
+		switch k {
+		case "Parent", "Annots", "B", "StructParents", "Thumb":
+			continue
+		}
+		page[k] = v
+	}
+	page["Parent"] = newRef(pagesObject)
+
+	pageID := w.reserve()
+	var body bytes.Buffer
+	w.serialize(&body, p.r, page)
+	w.object(pageID)
+	w.write(body.Bytes())
+	w.printf("\nendobj\n")
+	w.pages = append(w.pages, pageID)
+
+	// Then everything the page refers to, and everything they refer to
+	for len(w.queue) > 0 && w.err == nil {
+		job := w.queue[0]
+		w.queue = w.queue[1:]
+
+		body.Reset()
+		obj := job.r.object(job.id)
+		s, isStream := obj.(*stream)
+		if isStream {
+			d := make(dict, len(s.dict))
+			for k, v := range s.dict {
+				d[k] = v
+			}
+			d["Length"] = int64(len(s.data))
+			obj = d
+		}
+		w.serialize(&body, job.r, obj)
+
+		w.object(job.to)
+		w.write(body.Bytes())
+		if isStream {
+			w.printf("\nstream\n")
+			w.write(s.data)
+			w.printf("\nendstream")
+		}
+		w.printf("\nendobj\n")
+	}
+
+	return w.err
+}
+
+// serialize writes an object from r in pdf syntax, objects it refers to are
+// numbered and queued to be written
+func (w *Writer) serialize(buf *bytes.Buffer, r *Reader, obj interface{}) {
+	switch v := obj.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case name:
+		buf.WriteByte('/')
+		for i := 0; i < len(v); i++ {
+			if c := v[i]; c < '!' || c > '~' || c == '#' || isDelimiter(c) {
+				fmt.Fprintf(buf, "#%02x", c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	case str:
+		fmt.Fprintf(buf, "<%x>", string(v))
+	case array:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			w.serialize(buf, r, item)
+		}
+		buf.WriteByte(']')
+	case dict:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+
+		buf.WriteString("<<")
+		for _, k := range keys {
+			buf.WriteByte(' ')
+			w.serialize(buf, r, name(k))
+			buf.WriteByte(' ')
+			w.serialize(buf, r, v[name(k)])
+		}
+		buf.WriteString(" >>")
+	case newRef:
+		fmt.Fprintf(buf, "%d 0 R", int(v))
+	case ref:
+		// Other pages, and the page tree through them, aren't copied
+		switch r.dict(v)["Type"] {
+		case name("Page"), name("Pages"), name("Catalog"):
+			buf.WriteString("null")
+			return
+		}
+
+		ids := w.copied[r]
+		id, isa := ids[v.id]
+		if !isa {
+			id = w.reserve()
+			ids[v.id] = id
+			w.queue = append(w.queue, copyJob{r: r, id: v.id, to: id})
+		}
+		fmt.Fprintf(buf, "%d 0 R", id)
+	default:
+		// Streams can only be referred to, never inlined
+		buf.WriteString("null")
+	}
+}
+
+// Close writes the page tree, catalog and cross reference table
+func (w *Writer) Close() error {
+	if len(w.pages) == 0 && w.err == nil {
+		return errors.New("a pdf needs at least one page")
+	}
+
+	w.write(w.tail())
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// Size is how big the pdf would be if it were closed now, so pages can be
+// added until it's as big as it's allowed to be
+func (w *Writer) Size() int64 {
+	return w.n + int64(len(w.tail()))
+}
+
+// tail is the page tree, catalog and cross reference table that finish off
+// what's been written so far
+func (w *Writer) tail() []byte {
+	var b bytes.Buffer
+	offsets := append([]int64(nil), w.offsets...)
+
+	offsets[pagesObject-1] = w.n
+	fmt.Fprintf(&b, "%d 0 obj\n<< /Type /Pages /Count %d /Kids [", pagesObject, len(w.pages))
+	for _, id := range w.pages {
+		fmt.Fprintf(&b, " %d 0 R", id)
+	}
+	b.WriteString(" ] >>\nendobj\n")
+
+	offsets[catalogObject-1] = w.n + int64(b.Len())
+	fmt.Fprintf(&b, "%d 0 obj\n<< /Type /Catalog /Pages %d 0 R >>\nendobj\n", catalogObject, pagesObject)
+
+	xref := w.n + int64(b.Len())
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, catalogObject, xref)
+
+	return b.Bytes()
+}
@@ -0,0 +1,143 @@
+package pdf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// layered is three pages with a text layer over a shared image, with the
+// page size and resources inherited from the page tree
+func layered() []byte {
+	return handmade(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 /MediaBox [0 0 200 100] /Resources << /Font << /F1 6 0 R >> /XObject << /Im0 7 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 8 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 9 0 R /Annots [10 0 R] >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 8 0 R /Rotate 90 >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 1 >>\nstream\n\x80\nendstream",
+		"<< /Length 46 >>\nstream\nq 200 0 0 100 0 0 cm /Im0 Do Q BT (hello) Tj ET\nendstream",
+		"<< /Length 28 >>\nstream\nBT /F1 12 Tf (second) Tj ET\nendstream",
+		"<< /Type /Annot /Subtype /Link /Rect [0 0 1 1] /Dest [3 0 R /Fit] >>",
+	)
+}
+
+func TestCopyPage(t *testing.T) {
+	r, err := NewReader(layered())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := r.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, i := range []int{0, 1, 2} {
+		if err := w.CopyPage(pages[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	if n := strings.Count(out, "/BaseFont /Helvetica"); n != 1 {
+		t.Errorf("shared font written %d times", n)
+	}
+	if n := strings.Count(out, "(hello)"); n != 1 {
+		t.Errorf("shared content written %d times", n)
+	}
+	if strings.Contains(out, "/Annot") {
+		t.Error("annotations were copied")
+	}
+
+	copied, err := NewReader(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := copied.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d pages, expected 3", len(got))
+	}
+
+	for i, page := range got {
+		if page.Width != 200 || page.Height != 100 {
+			t.Errorf("page %d is %gx%g, expected the inherited 200x100", i+1, page.Width, page.Height)
+		}
+		if !page.HasImage() {
+			t.Errorf("page %d lost its image", i+1)
+		}
+		if copied.dict(page.inherited["Resources"])["Font"] == nil {
+			t.Errorf("page %d lost its fonts", i+1)
+		}
+	}
+
+	contents, _ := copied.resolve(got[1].dict["Contents"]).(*stream)
+	if contents == nil || !strings.Contains(string(contents.data), "(second)") {
+		t.Error("text layer wasn't copied")
+	}
+	if got[2].dict["Rotate"] != int64(90) {
+		t.Errorf("rotation is %v, expected 90", got[2].dict["Rotate"])
+	}
+}
+
+func TestCopyPageFromSeveral(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, data := range [][]byte{layered(), onePage("<< /Type /XObject /Subtype /Image /Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 1 >>\nstream\n\x00\nendstream")} {
+		r, err := NewReader(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, err := r.Pages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.CopyPage(pages[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := read(t, buf.Bytes()); err != nil || n != 2 {
+		t.Errorf("read %d pages: %v", n, err)
+	}
+}
+
+// Size knows how big the pdf will be before it's closed
+func TestSize(t *testing.T) {
+	r, err := NewReader(layered())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := r.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 1; n <= len(pages); n++ {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		for _, page := range pages[:n] {
+			if err := w.CopyPage(page); err != nil {
+				t.Fatal(err)
+			}
+		}
+		size := w.Size()
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(buf.Len()) {
+			t.Errorf("%d pages sized at %d, closed at %d", n, size, buf.Len())
+		}
+	}
+}
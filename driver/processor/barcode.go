@@ -0,0 +1,285 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/freman/scantp/driver/pdf"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/makiuchi-d/gozxing/qrcode"
+
+	// Formats scanners save pages as
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/tiff"
+)
+
+func init() {
+	Register("barcode", newBarcode)
+}
+
+var barcodeReaders = map[string]func() gozxing.Reader{
+	"qr":         qrcode.NewQRCodeReader,
+	"datamatrix": func() gozxing.Reader { return datamatrix.NewDataMatrixReader() },
+	"code128":    oned.NewCode128Reader,
+	"code39":     oned.NewCode39Reader,
+	"code93":     oned.NewCode93Reader,
+	"ean":        func() gozxing.Reader { return oned.NewMultiFormatUPCEANReader(nil) },
+	"itf":        oned.NewITFReader,
+	"codabar":    oned.NewCodaBarReader,
+}
+
+var defaultBarcodeFormats = []string{"qr", "code128", "code39"}
+
+// barcode reads barcodes and QR codes off cover and separator sheets, the
+// value can pick the path and name a document is delivered to and separator
+// sheets can split a document into several
+type barcode struct {
+	formats []string
+	value   *regexp.Regexp
+	split   bool
+	pages   int
+}
+
+func newBarcode(fn func(v interface{}) error) (Processor, error) {
+	config := struct {
+		Formats []string `toml:"formats"`
+		Value   string   `toml:"value"`
+		Split   bool     `toml:"split"`
+		Pages   int      `toml:"pages"`
+	}{
+		Formats: defaultBarcodeFormats,
+		Value:   "^(?P<path>[^/]+)$",
+		Pages:   1,
+	}
+	if err := fn(&config); err != nil {
+		return nil, err
+	}
+
+	for _, format := range config.Formats {
+		if _, exists := barcodeReaders[format]; !exists {
+			return nil, fmt.Errorf("unknown format %q", format)
+		}
+	}
+
+	value, err := regexp.Compile(config.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+
+	if config.Pages < 0 {
+		return nil, errors.New("pages can't be negative")
+	}
+
+	return &barcode{
+		formats: config.Formats,
+		value:   value,
+		split:   config.Split,
+		pages:   config.Pages,
+	}, nil
+}
+
+// scan looks for a barcode whose value matches on an image
+func (b *barcode) scan(img image.Image) (map[string]string, bool) {
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return nil, false
+	}
+
+	hints := map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	}
+
+	for _, format := range b.formats {
+		result, err := barcodeReaders[format]().Decode(bmp, hints)
+		if err != nil {
+			continue
+		}
+
+		match := b.value.FindStringSubmatch(result.GetText())
+		if match == nil {
+			continue
+		}
+
+		values := make(map[string]string)
+		for i, group := range b.value.SubexpNames() {
+			if group != "" && match[i] != "" {
+				values[group] = match[i]
+			}
+		}
+		return values, true
+	}
+
+	return nil, false
+}
+
+// route applies the values from a barcode to a document
+func route(doc *Document, values map[string]string) {
+	dir, name := path.Split(doc.Path)
+	ext := path.Ext(name)
+
+	if v, isa := values["path"]; isa {
+		doc.VirtualPath = v
+	}
+	if v, isa := values["dir"]; isa {
+		dir = "/" + strings.Trim(path.Clean("/"+v), "/") + "/"
+	}
+	if v, isa := values["name"]; isa {
+		name = strings.ReplaceAll(v, "/", "_") + ext
+	}
+
+	doc.Path = path.Join(dir, name)
+}
+
+func (b *barcode) Process(doc *Document) ([]*Document, error) {
+	if strings.EqualFold(path.Ext(doc.Path), ".pdf") {
+		return b.processPDF(doc)
+	}
+
+	f, err := doc.Open()
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		// Not an image we can read so it can't have a barcode we can read
+		return []*Document{doc}, nil
+	}
+
+	values, found := b.scan(img)
+	if !found {
+		return []*Document{doc}, nil
+	}
+
+	// A separator sheet on its own has nothing to separate
+	if b.split {
+		doc.log("barcode").Info("dropping separator sheet")
+		return nil, nil
+	}
+
+	out := doc.With(doc.Path, doc.File)
+	route(out, values)
+	return []*Document{out}, nil
+}
+
+func (b *barcode) processPDF(doc *Document) ([]*Document, error) {
+	data, err := ioutil.ReadFile(doc.File)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := pdf.NewReader(data)
+	if err != nil {
+		doc.log("barcode").WithError(err).Warn("unable to read, passing it on as is")
+		return []*Document{doc}, nil
+	}
+
+	pages, err := r.Pages()
+	if err != nil {
+		doc.log("barcode").WithError(err).Warn("unable to read, passing it on as is")
+		return []*Document{doc}, nil
+	}
+
+	if !b.split {
+		for i, page := range pages {
+			if b.pages > 0 && i >= b.pages {
+				break
+			}
+			if values, found := b.scanPage(page); found {
+				out := doc.With(doc.Path, doc.File)
+				route(out, values)
+				return []*Document{out}, nil
+			}
+		}
+		return []*Document{doc}, nil
+	}
+
+	// Every separator sheet starts a new document, routed by its barcode
+	type section struct {
+		values map[string]string
+		pages  []*pdf.Page
+	}
+	sections := []*section{{}}
+	for _, page := range pages {
+		if values, found := b.scanPage(page); found {
+			sections = append(sections, &section{values: values})
+			continue
+		}
+		current := sections[len(sections)-1]
+		current.pages = append(current.pages, page)
+	}
+
+	if len(sections) == 1 {
+		return []*Document{doc}, nil
+	}
+
+	base := strings.TrimSuffix(doc.Path, path.Ext(doc.Path))
+	var docs []*Document
+	for _, s := range sections {
+		if len(s.pages) == 0 {
+			continue
+		}
+
+		file, err := writePages(doc, s.pages)
+		if err != nil {
+			return nil, err
+		}
+
+		out := doc.With(fmt.Sprintf("%s-%d.pdf", base, len(docs)+1), file)
+		route(out, s.values)
+		docs = append(docs, out)
+	}
+
+	doc.log("barcode").Infof("split into %d documents", len(docs))
+	return docs, nil
+}
+
+func (b *barcode) scanPage(page *pdf.Page) (map[string]string, bool) {
+	if !page.HasImage() {
+		return nil, false
+	}
+
+	img, err := page.Decode()
+	if err != nil {
+		return nil, false
+	}
+
+	return b.scan(img)
+}
+
+// writePages copies pages whole into a new pdf in the document's working
+// directory, so text layers from scanners that OCR come along
+func writePages(doc *Document, pages []*pdf.Page) (string, error) {
+	f, err := doc.Create(".pdf")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w := pdf.NewWriter(f)
+	for _, page := range pages {
+		if err := w.CopyPage(page); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	if err := f.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return "", err
+	}
+
+	return f.Name(), nil
+}
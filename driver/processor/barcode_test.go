@@ -0,0 +1,230 @@
+package processor
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/freman/scantp/driver/pdf"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// qr is a sheet with a QR code saying text on it
+func qr(t *testing.T, text string) image.Image {
+	t.Helper()
+
+	matrix, err := qrcode.NewQRCodeWriter().Encode(text, gozxing.BarcodeFormat_QR_CODE, 200, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, matrix.GetWidth(), matrix.GetHeight()))
+	for y := 0; y < matrix.GetHeight(); y++ {
+		for x := 0; x < matrix.GetWidth(); x++ {
+			if !matrix.Get(x, y) {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+// sheets builds a scanned pdf out of images, one per page
+func sheets(t *testing.T, images ...image.Image) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := pdf.NewWriter(&buf)
+	for _, img := range images {
+		encoded, err := pdf.Encode(img, 72)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddPage(encoded); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// pageCount reads how many pages a document came out with
+func pageCount(t *testing.T, doc *Document) int {
+	t.Helper()
+
+	r, err := pdf.NewReader([]byte(read(t, doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := r.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(pages)
+}
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		values            map[string]string
+		virtualPath, path string
+	}{
+		{map[string]string{}, "docs", "/in/scan.pdf"},
+		{map[string]string{"path": "archive"}, "archive", "/in/scan.pdf"},
+		{map[string]string{"dir": "contracts/2020"}, "docs", "/contracts/2020/scan.pdf"},
+		{map[string]string{"dir": "../../etc"}, "docs", "/etc/scan.pdf"},
+		{map[string]string{"name": "invoice 12"}, "docs", "/in/invoice 12.pdf"},
+		{map[string]string{"name": "a/../b"}, "docs", "/in/a_.._b.pdf"},
+		{map[string]string{"path": "archive", "dir": "/", "name": "x"}, "archive", "/x.pdf"},
+	}
+
+	for _, test := range tests {
+		doc := &Document{VirtualPath: "docs", Path: "/in/scan.pdf"}
+		route(doc, test.values)
+		if doc.VirtualPath != test.virtualPath || doc.Path != test.path {
+			t.Errorf("%v routed to %s %s, expected %s %s", test.values, doc.VirtualPath, doc.Path, test.virtualPath, test.path)
+		}
+	}
+}
+
+// A cover sheet photographed on its own is routed by its code
+func TestBarcodeImage(t *testing.T) {
+	p, err := configure(t, "type = \"barcode\"\nvalue = '^(?P<path>\\w+):(?P<name>.+)$'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, qr(t, "archive:contract 12")); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := p.Process(document(t, "/scan.png", buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].VirtualPath != "archive" || docs[0].Path != "/contract 12.png" {
+		t.Fatalf("routed to %+v", docs)
+	}
+}
+
+// Codes that don't match the value are ignored
+func TestBarcodeIgnoresOtherCodes(t *testing.T) {
+	p, err := configure(t, `type = "barcode"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := p.Process(document(t, "/scan.pdf", sheets(t, qr(t, "not/a path"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].VirtualPath != "docs" {
+		t.Errorf("routed to %+v", docs)
+	}
+}
+
+// Only the first pages are looked at for a cover sheet
+func TestBarcodePages(t *testing.T) {
+	scan := sheets(t, page(255), qr(t, "archive"), page(255))
+
+	tests := map[string]string{
+		"1": "docs",
+		"2": "archive",
+		"0": "archive",
+	}
+	for pages, want := range tests {
+		p, err := configure(t, "type = \"barcode\"\npages = "+pages)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		docs, err := p.Process(document(t, "/scan.pdf", scan))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(docs) != 1 || docs[0].VirtualPath != want {
+			t.Errorf("pages = %s routed to %+v, expected %s", pages, docs, want)
+			continue
+		}
+		if got := pageCount(t, docs[0]); got != 3 {
+			t.Errorf("pages = %s kept %d pages", pages, got)
+		}
+	}
+}
+
+func TestBarcodeSplit(t *testing.T) {
+	p, err := configure(t, "type = \"barcode\"\nsplit = true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan := sheets(t,
+		page(0),
+		qr(t, "archive"), page(0), page(0),
+		// Two separators in a row leave nothing between them
+		qr(t, "archive"), qr(t, "invoices"), page(0),
+	)
+
+	docs, err := p.Process(document(t, "/2020/scan.pdf", scan))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		virtualPath, path string
+		pages             int
+	}{
+		{"docs", "/2020/scan-1.pdf", 1},
+		{"archive", "/2020/scan-2.pdf", 2},
+		{"invoices", "/2020/scan-3.pdf", 1},
+	}
+	if len(docs) != len(want) {
+		t.Fatalf("split into %d documents", len(docs))
+	}
+	for i, w := range want {
+		if docs[i].VirtualPath != w.virtualPath || docs[i].Path != w.path {
+			t.Errorf("document %d went to %s %s, expected %s %s", i, docs[i].VirtualPath, docs[i].Path, w.virtualPath, w.path)
+		}
+		if got := pageCount(t, docs[i]); got != w.pages {
+			t.Errorf("document %d has %d pages, expected %d", i, got, w.pages)
+		}
+	}
+}
+
+// A separator sheet photographed on its own has nothing to separate
+func TestBarcodeSplitDropsLoneSeparator(t *testing.T) {
+	p, err := configure(t, "type = \"barcode\"\nsplit = true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, qr(t, "archive")); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := p.Process(document(t, "/scan.png", buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 0 {
+		t.Errorf("kept %+v", docs)
+	}
+}
+
+func TestBarcodeConfiguration(t *testing.T) {
+	for _, config := range []string{
+		"type = \"barcode\"\nformats = [\"morse\"]",
+		"type = \"barcode\"\nvalue = \"(\"",
+		"type = \"barcode\"\npages = -1",
+	} {
+		if _, err := configure(t, config); err == nil {
+			t.Errorf("%q was accepted", config)
+		}
+	}
+}
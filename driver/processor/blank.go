@@ -0,0 +1,178 @@
+package processor
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/freman/scantp/driver/pdf"
+)
+
+func init() {
+	Register("blank", newBlank)
+}
+
+// minBlankCoverage is how much of a page its image has to cover for the
+// page to be judged by it
+const minBlankCoverage = 0.8
+
+// blank removes the empty pages duplex scanning leaves behind
+type blank struct {
+	threshold float64
+	ink       uint8
+	margin    float64
+}
+
+func newBlank(fn func(v interface{}) error) (Processor, error) {
+	config := struct {
+		Threshold float64 `toml:"threshold"`
+		Ink       int     `toml:"ink"`
+		Margin    float64 `toml:"margin"`
+	}{
+		Threshold: 0.5,
+		Ink:       160,
+		Margin:    5,
+	}
+	if err := fn(&config); err != nil {
+		return nil, err
+	}
+
+	if config.Threshold < 0 || config.Threshold > 100 {
+		return nil, errors.New("threshold must be between 0 and 100")
+	}
+	if config.Ink < 1 || config.Ink > 255 {
+		return nil, errors.New("ink must be between 1 and 255")
+	}
+	if config.Margin < 0 || config.Margin >= 50 {
+		return nil, errors.New("margin must be between 0 and 50")
+	}
+
+	return &blank{
+		threshold: config.Threshold,
+		ink:       uint8(config.Ink),
+		margin:    config.Margin,
+	}, nil
+}
+
+// isBlank reports whether the share of pixels darker than ink, ignoring the
+// margins where scanners leave shadows, is under the threshold
+func (b *blank) isBlank(img image.Image) bool {
+	bounds := img.Bounds()
+	mx := int(float64(bounds.Dx()) * b.margin / 100)
+	my := int(float64(bounds.Dy()) * b.margin / 100)
+	area := image.Rect(bounds.Min.X+mx, bounds.Min.Y+my, bounds.Max.X-mx, bounds.Max.Y-my)
+	if area.Empty() {
+		return false
+	}
+
+	total := area.Dx() * area.Dy()
+	limit := int(float64(total) * b.threshold / 100)
+	dark := 0
+
+	// Luma is all that matters so read it straight out of the common types
+	var luma func(x, y int) uint8
+	switch img := img.(type) {
+	case *image.Gray:
+		luma = func(x, y int) uint8 { return img.Pix[img.PixOffset(x, y)] }
+	case *image.YCbCr:
+		luma = func(x, y int) uint8 { return img.Y[img.YOffset(x, y)] }
+	default:
+		luma = func(x, y int) uint8 { return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y }
+	}
+
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			if luma(x, y) < b.ink {
+				if dark++; dark > limit {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+func (b *blank) Process(doc *Document) ([]*Document, error) {
+	if strings.EqualFold(path.Ext(doc.Path), ".pdf") {
+		return b.processPDF(doc)
+	}
+
+	f, err := doc.Open()
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err == nil && b.isBlank(img) {
+		// A lone image is the whole document, dropping it would lose the
+		// upload without a trace if the check got it wrong
+		doc.log("blank").Warn("image looks blank, passing it on as is")
+	}
+
+	return []*Document{doc}, nil
+}
+
+func (b *blank) processPDF(doc *Document) ([]*Document, error) {
+	data, err := ioutil.ReadFile(doc.File)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := pdf.NewReader(data)
+	if err != nil {
+		doc.log("blank").WithError(err).Warn("unable to read, passing it on as is")
+		return []*Document{doc}, nil
+	}
+
+	pages, err := r.Pages()
+	if err != nil {
+		doc.log("blank").WithError(err).Warn("unable to read, passing it on as is")
+		return []*Document{doc}, nil
+	}
+
+	var keep []*pdf.Page
+	for _, page := range pages {
+		if !b.blankPage(page) {
+			keep = append(keep, page)
+		}
+	}
+
+	removed := len(pages) - len(keep)
+	switch {
+	case removed == 0:
+		return []*Document{doc}, nil
+	case len(keep) == 0:
+		// Better to deliver a blank document than lose one the check got wrong
+		doc.log("blank").Warn("every page looks blank, passing it on as is")
+		return []*Document{doc}, nil
+	}
+
+	file, err := writePages(doc, keep)
+	if err != nil {
+		doc.log("blank").WithError(err).Warn("unable to remove blank pages, passing it on as is")
+		return []*Document{doc}, nil
+	}
+
+	doc.log("blank").WithField("pages", len(pages)).Infof("removed %d blank pages", removed)
+	return []*Document{doc.With(doc.Path, file)}, nil
+}
+
+// blankPage only counts pages it can see, anything it can't decode stays.
+// So does a page whose image doesn't cover most of it, that's a logo or
+// picture on a page of text or drawings rather than a scan
+func (b *blank) blankPage(page *pdf.Page) bool {
+	if !page.HasImage() || page.ImageCoverage() < minBlankCoverage {
+		return false
+	}
+
+	img, err := page.Decode()
+	if err != nil {
+		return false
+	}
+
+	return b.isBlank(img)
+}
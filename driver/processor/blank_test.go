@@ -0,0 +1,208 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/freman/scantp/driver/pdf"
+)
+
+// page fills a small greyscale scan with one shade
+func page(shade uint8) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range img.Pix {
+		img.Pix[i] = shade
+	}
+	return img
+}
+
+// scanned builds a pdf like an OCRing scanner would, each page drawing its
+// image under a text layer. Pages are "blank", "dark", "text" for a page
+// with nothing but text or "logo" for a page of text with a small white
+// image in the corner
+func scanned(t *testing.T, pages ...string) string {
+	t.Helper()
+
+	var objects []string
+	add := func(obj string) int {
+		objects = append(objects, obj)
+		return len(objects) + 2
+	}
+
+	var kids []string
+	for i, kind := range pages {
+		text := fmt.Sprintf("BT /F1 12 Tf (page %d) Tj ET", i+1)
+		resources := ""
+		content := text
+
+		if kind != "text" {
+			shade := uint8(255)
+			if kind == "dark" {
+				shade = 0
+			}
+			img, err := pdf.Encode(page(shade), 72)
+			if err != nil {
+				t.Fatal(err)
+			}
+			imageID := add(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width 100 /Height 100 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", len(img.Data), img.Data))
+			resources = fmt.Sprintf(" /XObject << /Im0 %d 0 R >>", imageID)
+			content = "q 100 0 0 100 0 0 cm /Im0 Do Q " + text
+			if kind == "logo" {
+				content = "q 10 0 0 10 5 85 cm /Im0 Do Q " + text
+			}
+		}
+
+		contents := add(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+		id := add(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 100 100] /Resources << /Font << /F1 << /Type /Font /Subtype /Type1 /BaseFont /Helvetica >> >>%s >> /Contents %d 0 R >>", resources, contents))
+		kids = append(kids, fmt.Sprintf("%d 0 R", id))
+	}
+
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	fmt.Fprintf(&b, "1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	fmt.Fprintf(&b, "2 0 obj\n<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), len(kids))
+	for i, obj := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+3, obj)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.String()
+}
+
+func TestBlankKeepsTextLayer(t *testing.T) {
+	p, err := configure(t, `type = "blank"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := p.Process(document(t, "/scan.pdf", scanned(t, "dark", "blank", "text", "blank")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("got %d documents, expected 1", len(docs))
+	}
+
+	out := read(t, docs[0])
+	for _, want := range []string{"(page 1)", "(page 3)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("text layer %s was lost", want)
+		}
+	}
+	for _, blank := range []string{"(page 2)", "(page 4)"} {
+		if strings.Contains(out, blank) {
+			t.Errorf("blank %s was kept", blank)
+		}
+	}
+
+	r, err := pdf.NewReader([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := r.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Errorf("got %d pages, expected 2", len(pages))
+	}
+}
+
+func TestBlankPassesOn(t *testing.T) {
+	p, err := configure(t, `type = "blank"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var white bytes.Buffer
+	if err := png.Encode(&white, page(255)); err != nil {
+		t.Fatal(err)
+	}
+	var dark bytes.Buffer
+	if err := png.Encode(&dark, page(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		path, content string
+	}{
+		"blank image":      {"/scan.png", white.String()},
+		"image":            {"/scan.png", dark.String()},
+		"every page blank": {"/scan.pdf", scanned(t, "blank", "blank")},
+		"nothing blank":    {"/scan.pdf", scanned(t, "dark", "text")},
+		"not a pdf":        {"/scan.pdf", "%PDF-1.4 truncated"},
+	}
+
+	for name, test := range tests {
+		doc := document(t, test.path, test.content)
+		docs, err := p.Process(doc)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(docs) != 1 || docs[0].File != doc.File {
+			t.Errorf("%s: wasn't passed on as is", name)
+		}
+	}
+}
+
+func TestBlankInk(t *testing.T) {
+	b := &blank{threshold: 0.5, ink: 160, margin: 5}
+
+	speck := image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range speck.Pix {
+		speck.Pix[i] = 255
+	}
+	speck.SetGray(50, 50, color.Gray{})
+	if !b.isBlank(speck) {
+		t.Error("a speck of dust isn't blank")
+	}
+
+	// Shadows along the edge are ignored
+	shadow := image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range shadow.Pix {
+		if i%100 < 3 {
+			continue
+		}
+		shadow.Pix[i] = 255
+	}
+	if !b.isBlank(shadow) {
+		t.Error("an edge shadow isn't blank")
+	}
+
+	if b.isBlank(page(0)) {
+		t.Error("a dark page is blank")
+	}
+}
+
+// A white logo on a page of text isn't a blank scan
+func TestBlankKeepsLogoPages(t *testing.T) {
+	p, err := configure(t, `type = "blank"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := p.Process(document(t, "/letter.pdf", scanned(t, "logo", "blank", "logo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("got %d documents", len(docs))
+	}
+
+	r, err := pdf.NewReader([]byte(read(t, docs[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := r.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Errorf("kept %d pages, expected the 2 with a logo", len(pages))
+	}
+}
@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/freman/scantp/driver/run"
+)
+
+const (
+	defaultExecTimeout     = 10 * time.Minute
+	defaultExecConcurrency = 1
+)
+
+func init() {
+	Register("exec", func(fn func(v interface{}) error) (Processor, error) {
+		var config ExecConfig
+		if err := fn(&config); err != nil {
+			return nil, err
+		}
+		return NewExec(config)
+	})
+}
+
+// ExecConfig configures an Exec processor
+type ExecConfig struct {
+	Command     []string `toml:"command"`
+	Extension   string   `toml:"extension"`
+	Timeout     string   `toml:"timeout"`
+	Concurrency int      `toml:"concurrency"`
+	Required    bool     `toml:"required"`
+}
+
+// Exec runs documents through an external command such as ocrmypdf, unless
+// it's required a failing command just passes the original along
+type Exec struct {
+	command   []string
+	extension string
+	timeout   time.Duration
+	required  bool
+	slots     chan struct{}
+}
+
+func NewExec(config ExecConfig) (e *Exec, err error) {
+	if len(config.Command) == 0 {
+		return nil, errors.New("command is required")
+	}
+
+	e = &Exec{
+		command:   config.Command,
+		extension: config.Extension,
+		timeout:   defaultExecTimeout,
+		required:  config.Required,
+	}
+
+	if config.Timeout != "" {
+		if e.timeout, err = time.ParseDuration(config.Timeout); err != nil {
+			return nil, fmt.Errorf("failure while parsing timeout: %w", err)
+		}
+	}
+
+	concurrency := config.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultExecConcurrency
+	}
+	if concurrency < 0 {
+		return nil, errors.New("concurrency must be at least 1")
+	}
+	e.slots = make(chan struct{}, concurrency)
+
+	return e, nil
+}
+
+func (e *Exec) Process(doc *Document) ([]*Document, error) {
+	p := doc.Path
+	ext := path.Ext(p)
+	if e.extension != "" {
+		p = strings.TrimSuffix(p, ext) + e.extension
+		ext = e.extension
+	}
+
+	out, err := doc.Create(ext)
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+
+	if err := e.run(doc.File, out.Name()); err != nil {
+		if e.required {
+			return nil, err
+		}
+		doc.log("exec").WithError(err).Warn("processing failed, passing on the original")
+		return []*Document{doc}, nil
+	}
+
+	return []*Document{doc.With(p, out.Name())}, nil
+}
+
+// run executes the command, waiting for a free slot first so only so many
+// run at once
+func (e *Exec) run(input, output string) error {
+	e.slots <- struct{}{}
+	defer func() { <-e.slots }()
+
+	replacer := strings.NewReplacer("{input}", input, "{output}", output)
+	args := make([]string, len(e.command))
+	for i, arg := range e.command {
+		args[i] = replacer.Replace(arg)
+	}
+
+	cmd := &run.Command{Args: args, Timeout: e.timeout}
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(output)
+	if err != nil {
+		return fmt.Errorf("no output produced: %w", err)
+	}
+	if info.Size() == 0 {
+		return errors.New("output is empty")
+	}
+
+	return nil
+}
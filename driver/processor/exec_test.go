@@ -0,0 +1,73 @@
+//go:build !windows
+// +build !windows
+
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec(t *testing.T) {
+	p, err := configure(t, `type = "exec"
+command = ["sh", "-c", "tr a-z A-Z < {input} > {output}"]
+extension = ".txt"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := p.Process(document(t, "/scan.pdf", "scan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].Path != "/scan.txt" || read(t, docs[0]) != "SCAN" {
+		t.Errorf("unexpected documents %v", docs)
+	}
+}
+
+func TestExecFailure(t *testing.T) {
+	for _, command := range []string{
+		`["sh", "-c", "echo broken >&2; exit 1"]`,
+		`["true"]`,
+	} {
+		p, err := configure(t, "type = \"exec\"\ncommand = "+command)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Optional commands pass the original along
+		doc := document(t, "/scan.pdf", "scan")
+		docs, err := p.Process(doc)
+		if err != nil || len(docs) != 1 || docs[0] != doc {
+			t.Errorf("%s: got %v, %v", command, docs, err)
+		}
+
+		p, err = configure(t, "type = \"exec\"\nrequired = true\ncommand = "+command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Process(doc); err == nil {
+			t.Errorf("%s: required command succeeded", command)
+		}
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	p, err := configure(t, `type = "exec"
+required = true
+timeout = "100ms"
+command = ["sh", "-c", "sleep 10 & sleep 10"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	_, err = p.Process(document(t, "/scan.pdf", "scan"))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if took := time.Since(started); took > 5*time.Second {
+		t.Errorf("took %s to give up", took)
+	}
+}
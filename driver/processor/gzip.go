@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"path"
+)
+
+func init() {
+	Register("gzip", newGzip)
+}
+
+// defaultGzipLevel is what compress/gzip uses by default, spelt out so the
+// documentation can say what it is
+const defaultGzipLevel = 6
+
+// gzipper compresses documents and adds .gz to their name
+type gzipper struct {
+	level int
+}
+
+func newGzip(fn func(v interface{}) error) (Processor, error) {
+	config := struct {
+		Level int `toml:"level"`
+	}{Level: defaultGzipLevel}
+	if err := fn(&config); err != nil {
+		return nil, err
+	}
+
+	if config.Level < gzip.BestSpeed || config.Level > gzip.BestCompression {
+		return nil, errors.New("level must be between 1 and 9")
+	}
+
+	return &gzipper{level: config.Level}, nil
+}
+
+func (g *gzipper) Process(doc *Document) ([]*Document, error) {
+	in, err := doc.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	out, err := doc.Create(".gz")
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	zw, _ := gzip.NewWriterLevel(out, g.level)
+	zw.Name = path.Base(doc.Path)
+	if _, err := io.Copy(zw, in); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	return []*Document{doc.With(doc.Path+".gz", out.Name())}, nil
+}
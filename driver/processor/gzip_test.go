@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestGzipLevel(t *testing.T) {
+	p, err := configure(t, `type = "gzip"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := p.(*gzipper).level; level != defaultGzipLevel {
+		t.Errorf("default level is %d, expected %d", level, defaultGzipLevel)
+	}
+
+	for _, level := range []string{"-1", "0", "10"} {
+		if _, err := configure(t, "type = \"gzip\"\nlevel = "+level); err == nil {
+			t.Errorf("level %s was accepted", level)
+		}
+	}
+}
+
+func TestGzip(t *testing.T) {
+	p, err := configure(t, "type = \"gzip\"\nlevel = 9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := p.Process(document(t, "/2020/scan.pdf", "%PDF-1.4 scan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].Path != "/2020/scan.pdf.gz" {
+		t.Fatalf("unexpected documents %v", docs)
+	}
+
+	f, err := os.Open(docs[0].File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "%PDF-1.4 scan" || zr.Name != "scan.pdf" {
+		t.Errorf("decompressed %q named %q", buf, zr.Name)
+	}
+}
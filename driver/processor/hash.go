@@ -0,0 +1,75 @@
+package processor
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+)
+
+func init() {
+	Register("hash", newHasher)
+}
+
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// hasher adds a checksum file next to each document in the same format as
+// sha256sum and friends so it can be checked with them
+type hasher struct {
+	algorithm string
+	new       func() hash.Hash
+}
+
+func newHasher(fn func(v interface{}) error) (Processor, error) {
+	config := struct {
+		Algorithm string `toml:"algorithm"`
+	}{Algorithm: "sha256"}
+	if err := fn(&config); err != nil {
+		return nil, err
+	}
+
+	newHash, exists := hashes[config.Algorithm]
+	if !exists {
+		return nil, fmt.Errorf("unknown algorithm %q, expected md5, sha1, sha256 or sha512", config.Algorithm)
+	}
+
+	return &hasher{algorithm: config.Algorithm, new: newHash}, nil
+}
+
+func (h *hasher) Process(doc *Document) ([]*Document, error) {
+	in, err := doc.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	sum := h.new()
+	if _, err := io.Copy(sum, in); err != nil {
+		return nil, err
+	}
+
+	out, err := doc.Create("." + h.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	if _, err := fmt.Fprintf(out, "%s  %s\n", hex.EncodeToString(sum.Sum(nil)), path.Base(doc.Path)); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	return []*Document{doc, doc.With(doc.Path+"."+h.algorithm, out.Name())}, nil
+}
@@ -0,0 +1,45 @@
+package processor
+
+import (
+	"os"
+
+	"github.com/freman/scantp/driver/notify"
+)
+
+func init() {
+	Register("notify", newNotifier)
+}
+
+// notifier tells people about documents as they pass through, before
+// they're delivered. The document carries on unchanged
+type notifier struct {
+	notifiers notify.List
+}
+
+func newNotifier(fn func(v interface{}) error) (Processor, error) {
+	var config struct {
+		Notifier string `toml:"notifier"`
+	}
+	if err := fn(&config); err != nil {
+		return nil, err
+	}
+
+	n, err := notify.NewType(config.Notifier, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notifier{notifiers: notify.List{n}}, nil
+}
+
+func (n *notifier) Process(doc *Document) ([]*Document, error) {
+	e := notify.Event{Path: doc.VirtualPath, File: doc.Path, User: doc.User}
+	if info, err := os.Stat(doc.File); err == nil {
+		e.Bytes = info.Size()
+	}
+
+	// List sends in the background so the document isn't held up
+	n.notifiers.Notify(e)
+
+	return []*Document{doc}, nil
+}
@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/freman/scantp/driver/notify"
+)
+
+// sent is every message sent to a "processed" notifier
+var sent = make(chan *notify.Message, 4)
+
+type sender struct{}
+
+func (sender) Send(msg *notify.Message) error {
+	sent <- msg
+	return nil
+}
+
+func init() {
+	notify.Register("processed", func(fn func(v interface{}) error) (notify.Sender, error) {
+		return sender{}, nil
+	})
+}
+
+func TestNotify(t *testing.T) {
+	p, err := configure(t, "type = \"notify\"\nnotifier = \"processed\"\ntitle = \"{{.User}} sent {{.Name}}\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := document(t, "/2020/scan.pdf", "%PDF-1.4 scan")
+	doc.User = "alice"
+
+	docs, err := p.Process(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0] != doc {
+		t.Fatalf("document was changed %v", docs)
+	}
+
+	select {
+	case msg := <-sent:
+		if msg.Title != "alice sent scan.pdf" || msg.Path != "docs" || msg.Bytes != 13 {
+			t.Errorf("unexpected message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was sent")
+	}
+}
+
+func TestNotifyUnknown(t *testing.T) {
+	if _, err := configure(t, "type = \"notify\"\nnotifier = \"pigeon\""); err == nil {
+		t.Error("unknown notifier was accepted")
+	}
+}
@@ -0,0 +1,219 @@
+// Package processor is a chain of steps that uploads pass through before
+// they're handed to a driver. Processors are configured per path and run in
+// the order they're listed, in house processors can be added with Register
+package processor
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Processor transforms a document on its way to the driver, it returns the
+// documents to carry on with which lets it rename, replace, split or add
+// documents. Returning the document unchanged is fine too
+type Processor interface {
+	Process(doc *Document) ([]*Document, error)
+}
+
+// Factory creates a processor, fn decodes the processor's configuration
+type Factory func(fn func(v interface{}) error) (Processor, error)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a processor available to the configuration under name
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic("processor: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Names lists the registered processors
+func Names() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the processor named by the type in its configuration, if a
+// match pattern is configured only documents with a matching name are
+// passed to it
+func New(fn func(v interface{}) error) (Processor, error) {
+	var common struct {
+		Type  string `toml:"type"`
+		Match string `toml:"match"`
+	}
+	if err := fn(&common); err != nil {
+		return nil, err
+	}
+
+	registryMu.Lock()
+	factory, exists := registry[common.Type]
+	registryMu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown processor %q, expected one of %s", common.Type, strings.Join(Names(), ", "))
+	}
+
+	p, err := factory(fn)
+	if err != nil {
+		return nil, fmt.Errorf("processor %s: %w", common.Type, err)
+	}
+
+	if common.Match != "" {
+		if p, err = Match(common.Match, p); err != nil {
+			return nil, fmt.Errorf("processor %s: %w", common.Type, err)
+		}
+	}
+
+	return p, nil
+}
+
+// Match only passes documents with a name matching pattern to p, the rest
+// carry on untouched
+func Match(pattern string, p Processor) (Processor, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid match %q: %w", pattern, err)
+	}
+	return &matcher{Processor: p, pattern: pattern}, nil
+}
+
+type matcher struct {
+	Processor
+	pattern string
+}
+
+func (m *matcher) Process(doc *Document) ([]*Document, error) {
+	if matched, _ := path.Match(m.pattern, path.Base(doc.Path)); !matched {
+		return []*Document{doc}, nil
+	}
+	return m.Processor.Process(doc)
+}
+
+// Chain runs processors one after the other
+type Chain []Processor
+
+// Run passes the document through every processor, each document a
+// processor returns is passed to the next one
+func (c Chain) Run(doc *Document) ([]*Document, error) {
+	docs := []*Document{doc}
+	for _, p := range c {
+		var next []*Document
+		for _, d := range docs {
+			out, err := process(p, d)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, out...)
+		}
+		docs = next
+	}
+
+	return docs, nil
+}
+
+// process runs a single processor, a processor that panics on a document it
+// couldn't cope with fails the document rather than taking scantp down
+func process(p Processor, doc *Document) (docs []*Document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			doc.log(fmt.Sprintf("%T", p)).WithField("stack", string(debug.Stack())).Error("processor panicked")
+			docs, err = nil, fmt.Errorf("processing %s failed: %v", doc.Path, r)
+		}
+	}()
+
+	return p.Process(doc)
+}
+
+// Document is an upload on local disk
+type Document struct {
+	// VirtualPath is the name of the path the document was uploaded to
+	VirtualPath string
+	// User is who uploaded the document
+	User string
+	// Path is where the document will be written within the virtual path
+	Path string
+	// File holds the content of the document
+	File string
+
+	dir string
+}
+
+// NewDocument writes data into a fresh working directory, Cleanup removes
+// it along with any files processors created for the document
+func NewDocument(virtualPath, p string, data io.Reader) (doc *Document, size int64, err error) {
+	dir, err := ioutil.TempDir("", "scantp-processor")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	doc = &Document{VirtualPath: virtualPath, Path: p, dir: dir}
+	f, err := doc.Create(path.Ext(p))
+	if err != nil {
+		doc.Cleanup()
+		return nil, 0, err
+	}
+
+	size, err = io.Copy(f, data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		doc.Cleanup()
+		return nil, 0, err
+	}
+
+	doc.File = f.Name()
+	return doc, size, nil
+}
+
+// Create makes a new file in the document's working directory for a
+// processor to write its output into
+func (d *Document) Create(ext string) (*os.File, error) {
+	return ioutil.TempFile(d.dir, "*"+ext)
+}
+
+// Open opens the document's content for reading
+func (d *Document) Open() (*os.File, error) {
+	return os.Open(d.File)
+}
+
+// With returns a copy of the document with new content, sharing the same
+// working directory
+func (d *Document) With(p, file string) *Document {
+	c := *d
+	c.Path = p
+	c.File = file
+	return &c
+}
+
+// log is for processors to log what they did to the document
+func (d *Document) log(processor string) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{"path": d.VirtualPath, "file": d.Path, "processor": processor})
+}
+
+// Cleanup removes the working directory, it's shared by every document
+// derived from this one
+func (d *Document) Cleanup() {
+	os.RemoveAll(d.dir)
+}
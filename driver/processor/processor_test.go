@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configure creates a processor from its toml configuration
+func configure(t *testing.T, config string) (Processor, error) {
+	t.Helper()
+
+	var prim toml.Primitive
+	md, err := toml.Decode(config, &prim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(func(v interface{}) error {
+		return md.PrimitiveDecode(prim, v)
+	})
+}
+
+// document creates a document for a test, it's cleaned up when the test is
+func document(t *testing.T, p, content string) *Document {
+	t.Helper()
+
+	doc, _, err := NewDocument("docs", p, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(doc.Cleanup)
+	return doc
+}
+
+func read(t *testing.T, doc *Document) string {
+	t.Helper()
+
+	buf, err := ioutil.ReadFile(doc.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestNewUnknown(t *testing.T) {
+	if _, err := configure(t, `type = "nope"`); err == nil || !strings.Contains(err.Error(), "expected one of") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	p, err := configure(t, "type = \"rename\"\npattern = \"^scan\"\nreplace = \"renamed\"\nmatch = \"*.pdf\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"/scan.pdf": "/renamed.pdf",
+		"/scan.jpg": "/scan.jpg",
+	} {
+		docs, err := p.Process(document(t, name, "scan"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(docs) != 1 || docs[0].Path != want {
+			t.Errorf("%s: got %v, expected %s", name, docs, want)
+		}
+	}
+}
+
+type panicker struct{}
+
+func (panicker) Process(doc *Document) ([]*Document, error) {
+	var pages []int
+	return nil, fmt.Errorf("page %d", pages[3])
+}
+
+func TestChainRecovers(t *testing.T) {
+	chain := Chain{panicker{}}
+	docs, err := chain.Run(document(t, "/scan.pdf", "scan"))
+	if err == nil || !strings.Contains(err.Error(), "index out of range") || docs != nil {
+		t.Errorf("got %v, %v", docs, err)
+	}
+}
@@ -0,0 +1,51 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	Register("rename", newRename)
+}
+
+// rename rewrites the name of a document with a regular expression, the
+// directory it's going into is left alone
+type rename struct {
+	pattern *regexp.Regexp
+	replace string
+}
+
+func newRename(fn func(v interface{}) error) (Processor, error) {
+	var config struct {
+		Pattern string `toml:"pattern"`
+		Replace string `toml:"replace"`
+	}
+	if err := fn(&config); err != nil {
+		return nil, err
+	}
+
+	if config.Pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+
+	pattern, err := regexp.Compile(config.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	return &rename{pattern: pattern, replace: config.Replace}, nil
+}
+
+func (r *rename) Process(doc *Document) ([]*Document, error) {
+	dir, name := path.Split(doc.Path)
+	name = r.pattern.ReplaceAllString(name, r.replace)
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("renaming %s gave an invalid name %q", doc.Path, name)
+	}
+
+	return []*Document{doc.With(dir+name, doc.File)}, nil
+}
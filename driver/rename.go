@@ -0,0 +1,26 @@
+package driver
+
+import (
+	"fmt"
+)
+
+// renamePolicy decides which files a session may rename, some scanners
+// upload to a temporary name then rename it once they're done
+type renamePolicy string
+
+const (
+	renameNone    renamePolicy = "none"
+	renameSession renamePolicy = "session"
+	renamePath    renamePolicy = "path"
+)
+
+func parseRenamePolicy(s string) (renamePolicy, error) {
+	switch p := renamePolicy(s); p {
+	case "":
+		return renameSession, nil
+	case renameNone, renameSession, renamePath:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown allow_rename %q, expected none, session or path", s)
+}
@@ -0,0 +1,49 @@
+// Package retry tells failures worth delivering again from the ones that
+// will fail the same way however many times they're tried
+package retry
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrPermanent is matched by failures that trying again won't fix, uploads
+// that fail with one are given up on straight away rather than retried
+// until they're too old
+var ErrPermanent = errors.New("permanent failure")
+
+// Permanent marks err as a failure trying again won't fix, it still reads
+// and unwraps the same
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func (e *permanentError) Is(target error) bool {
+	return target == ErrPermanent
+}
+
+// PermanentStatus reports whether an http status says the request itself
+// was wrong, the server will answer the same way if it's sent again. Timeouts
+// and rate limiting are the exceptions since those pass
+func PermanentStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return code >= 400 && code < 500
+}
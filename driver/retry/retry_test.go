@@ -0,0 +1,49 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPermanent(t *testing.T) {
+	err := fmt.Errorf("sending scan.pdf: %w", Permanent(fmt.Errorf("scan.pdf: %w", os.ErrExist)))
+
+	if !errors.Is(err, ErrPermanent) {
+		t.Error("isn't permanent")
+	}
+	if !errors.Is(err, os.ErrExist) {
+		t.Error("lost what it wrapped")
+	}
+	if err.Error() != "sending scan.pdf: scan.pdf: file already exists" {
+		t.Errorf("reads %q", err)
+	}
+
+	if errors.Is(errors.New("connection refused"), ErrPermanent) {
+		t.Error("any error is permanent")
+	}
+	if Permanent(nil) != nil {
+		t.Error("nil became an error")
+	}
+}
+
+func TestPermanentStatus(t *testing.T) {
+	tests := map[int]bool{
+		200: false,
+		400: true,
+		401: true,
+		404: true,
+		408: false,
+		413: true,
+		429: false,
+		500: false,
+		503: false,
+	}
+
+	for code, want := range tests {
+		if got := PermanentStatus(code); got != want {
+			t.Errorf("%d: got %v, expected %v", code, got, want)
+		}
+	}
+}
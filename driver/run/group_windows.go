@@ -0,0 +1,13 @@
+package run
+
+import (
+	"os/exec"
+)
+
+// setGroup does nothing, windows has no process groups to put it in
+func setGroup(cmd *exec.Cmd) {}
+
+// killGroup can only kill the command itself
+func killGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,135 @@
+// Package run runs the external commands processors and drivers hand
+// documents to. Commands are started in their own process group so a
+// timeout kills everything they started, not just the command itself, and
+// only the end of what they print is kept
+package run
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxOutput is how much of what a command prints is kept for the error,
+	// the end is where the reason it failed usually is
+	maxOutput = 4096
+
+	// drainFor is how long to keep reading output after the command exits,
+	// anything it left running in the background may still hold it open
+	drainFor = time.Second
+)
+
+// Command is a command to run
+type Command struct {
+	Args []string
+	Dir  string
+	// Env is the whole environment, nil means scantp's own
+	Env   []string
+	Stdin io.Reader
+	// Timeout kills the command and everything it started if it runs for
+	// longer, zero means no limit
+	Timeout time.Duration
+}
+
+// Run runs the command and waits for it, anything but a zero exit is an
+// error with the end of its output in it
+func (c *Command) Run() error {
+	if len(c.Args) == 0 {
+		return fmt.Errorf("no command to run")
+	}
+
+	cmd := exec.Command(c.Args[0], c.Args[1:]...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	cmd.Stdin = c.Stdin
+	setGroup(cmd)
+
+	// The output goes through a pipe of our own so we decide when to stop
+	// reading, exec would wait for everything holding it open to exit
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cmd.Stdout, cmd.Stderr = w, w
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	out := &tail{max: maxOutput}
+	drained := make(chan struct{})
+	go func() {
+		io.Copy(out, r)
+		close(drained)
+	}()
+
+	var timedOut int32
+	var timer *time.Timer
+	if c.Timeout > 0 {
+		timer = time.AfterFunc(c.Timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			killGroup(cmd)
+		})
+	}
+
+	err = cmd.Wait()
+	if timer != nil {
+		timer.Stop()
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(drainFor):
+		r.Close()
+		<-drained
+	}
+
+	if atomic.LoadInt32(&timedOut) == 1 {
+		return fmt.Errorf("timed out after %s", c.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	return nil
+}
+
+// tail keeps the last max bytes written to it
+type tail struct {
+	mu        sync.Mutex
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+		t.truncated = true
+	}
+	return len(p), nil
+}
+
+func (t *tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := strings.TrimSpace(string(t.buf))
+	if t.truncated {
+		return "..." + s
+	}
+	return s
+}
@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package run
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	cmd := &Command{Args: []string{"sh", "-c", `read line; test "$line" = scan && test "$SCANTP" = yes`}, Env: []string{"SCANTP=yes"}, Stdin: strings.NewReader("scan\n")}
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	cmd := &Command{Args: []string{"sh", "-c", "echo starting; echo bad input >&2; exit 3"}}
+	err := cmd.Run()
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "starting\nbad input") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestRunOutputCapped(t *testing.T) {
+	cmd := &Command{Args: []string{"sh", "-c", "yes filler | head -c 100000; echo the end; exit 1"}}
+	err := cmd.Run()
+	if err == nil {
+		t.Fatal("command succeeded")
+	}
+	if msg := err.Error(); len(msg) > maxOutput+100 || !strings.HasSuffix(msg, "the end") || !strings.Contains(msg, ": ...") {
+		t.Errorf("unexpected error of %d bytes ending %q", len(msg), msg[len(msg)-20:])
+	}
+}
+
+// A command that leaves something running in the background holding on to
+// its output mustn't keep us waiting past the timeout
+func TestRunTimeoutKillsChildren(t *testing.T) {
+	cmd := &Command{Args: []string{"sh", "-c", "sleep 30 & sleep 30"}, Timeout: 100 * time.Millisecond}
+
+	started := time.Now()
+	err := cmd.Run()
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if took := time.Since(started); took > 5*time.Second {
+		t.Errorf("took %s to give up", took)
+	}
+}
+
+// Background processes left by a command that succeeded don't hold it up
+// for longer than it takes to give up on its output
+func TestRunBackgroundAfterExit(t *testing.T) {
+	cmd := &Command{Args: []string{"sh", "-c", "sleep 3 & echo done"}, Timeout: time.Minute}
+
+	started := time.Now()
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(started); took > drainFor+5*time.Second {
+		t.Errorf("took %s to return", took)
+	}
+}
@@ -0,0 +1,197 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v6"
+	"goftp.io/server"
+)
+
+const defaultPartSize = 16 // MiB
+
+type Driver struct {
+	client *minio.Client
+
+	configuration struct {
+		Endpoint  string `toml:"endpoint"`
+		Region    string `toml:"region"`
+		Bucket    string `toml:"bucket"`
+		Prefix    string `toml:"prefix"`
+		AccessKey string `toml:"access_key"`
+		SecretKey string `toml:"secret_key"`
+		Insecure  bool   `toml:"insecure"`
+		PartSize  uint64 `toml:"part_size"`
+	}
+}
+
+// objectKey maps a path within the driver to a key within the bucket
+func (d *Driver) objectKey(p string) string {
+	return d.configuration.Prefix + strings.Trim(p, "/")
+}
+
+// directoryKey maps a path within the driver to the prefix its children live under
+func (d *Driver) directoryKey(p string) string {
+	key := d.objectKey(p)
+	if key == "" || strings.HasSuffix(key, "/") {
+		return key
+	}
+	return key + "/"
+}
+
+func (d *Driver) Stat(p string) (server.FileInfo, error) {
+	if p == "/" || p == "" {
+		return &fileInfo{name: "/", isDir: true}, nil
+	}
+
+	info, err := d.client.StatObject(d.configuration.Bucket, d.objectKey(p), minio.StatObjectOptions{})
+	if err == nil {
+		return &fileInfo{
+			name:    path.Base(p),
+			size:    info.Size,
+			modTime: info.LastModified,
+		}, nil
+	}
+
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return nil, err
+	}
+
+	// There are no real directories, just objects sharing a prefix, so
+	// anything with at least one object under it counts as a directory
+	done := make(chan struct{})
+	defer close(done)
+
+	for obj := range d.client.ListObjectsV2(d.configuration.Bucket, d.directoryKey(p), false, done) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		return &fileInfo{name: path.Base(p), isDir: true}, nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+func (d *Driver) ListDir(p string, fn func(server.FileInfo) error) error {
+	prefix := d.directoryKey(p)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	for obj := range d.client.ListObjectsV2(d.configuration.Bucket, prefix, false, done) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+
+		name := strings.TrimPrefix(obj.Key, prefix)
+		if name == "" {
+			// The directory marker for the directory we're listing
+			continue
+		}
+
+		info := &fileInfo{
+			name:    strings.TrimSuffix(name, "/"),
+			size:    obj.Size,
+			modTime: obj.LastModified,
+			isDir:   strings.HasSuffix(name, "/"),
+		}
+
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Driver) MakeDir(p string) error {
+	if p == "/" || p == "" {
+		return errors.New("Directory already exists")
+	}
+
+	_, err := d.client.PutObject(d.configuration.Bucket, d.directoryKey(p), bytes.NewReader(nil), 0, minio.PutObjectOptions{
+		ContentType: "application/x-directory",
+	})
+
+	return err
+}
+
+func (d *Driver) PutFile(p string, stream io.Reader, appendData bool) (int64, error) {
+	if appendData {
+		return 0, errors.New("Appending to objects is not supported")
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(p))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// An unknown size makes minio stream the upload as a multipart upload,
+	// buffering only one part at a time
+	return d.client.PutObject(d.configuration.Bucket, d.objectKey(p), stream, -1, minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    d.configuration.PartSize * 1024 * 1024,
+	})
+}
+
+// Rename copies the object to its new key and removes the old one, there's no
+// such thing as a rename in S3
+func (d *Driver) Rename(from, to string) error {
+	src := minio.NewSourceInfo(d.configuration.Bucket, d.objectKey(from), nil)
+	dst, err := minio.NewDestinationInfo(d.configuration.Bucket, d.objectKey(to), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := d.client.CopyObject(dst, src); err != nil {
+		return err
+	}
+
+	return d.client.RemoveObject(d.configuration.Bucket, d.objectKey(from))
+}
+
+func (d *Driver) DeleteFile(p string) error {
+	return d.client.RemoveObject(d.configuration.Bucket, d.objectKey(p))
+}
+
+func NewDriver(fn func(v interface{}) error) (d *Driver, err error) {
+	d = &Driver{}
+	d.configuration.PartSize = defaultPartSize
+	if err = fn(&d.configuration); err != nil {
+		return nil, err
+	}
+
+	if d.configuration.Endpoint == "" || d.configuration.Bucket == "" {
+		return nil, errors.New("Configuration for s3 is invalid, required endpoint and bucket")
+	}
+
+	if d.configuration.PartSize < 5 {
+		return nil, errors.New("Configuration for s3 is invalid, part_size must be at least 5 (MiB)")
+	}
+
+	if d.configuration.Prefix = strings.TrimLeft(d.configuration.Prefix, "/"); d.configuration.Prefix != "" && !strings.HasSuffix(d.configuration.Prefix, "/") {
+		d.configuration.Prefix += "/"
+	}
+
+	d.client, err = minio.NewWithRegion(d.configuration.Endpoint, d.configuration.AccessKey, d.configuration.SecretKey, !d.configuration.Insecure, d.configuration.Region)
+	if err != nil {
+		return nil, fmt.Errorf("failure while creating s3 client: %w", err)
+	}
+
+	exists, err := d.client.BucketExists(d.configuration.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failure while checking s3 bucket: %w", err)
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("bucket %q does not exist", d.configuration.Bucket)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,165 @@
+package s3
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/minio/minio-go/v6"
+	"goftp.io/server"
+)
+
+func configure(config string) func(v interface{}) error {
+	return func(v interface{}) error {
+		_, err := toml.Decode(config, v)
+		return err
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	tests := map[string]string{
+		"no endpoint":    `bucket = "scans"`,
+		"no bucket":      `endpoint = "localhost:9000"`,
+		"tiny part size": "endpoint = \"localhost:9000\"\nbucket = \"scans\"\npart_size = 1",
+	}
+
+	for name, config := range tests {
+		if _, err := NewDriver(configure(config)); err == nil {
+			t.Errorf("%s: configuration was accepted", name)
+		}
+	}
+}
+
+// minioDriver connects to the MinIO named by SCANTP_TEST_S3_ENDPOINT, for
+// example one started with
+//
+//	docker run -p 9000:9000 -e MINIO_ROOT_USER=scantp -e MINIO_ROOT_PASSWORD=scantpsecret minio/minio server /data
+//
+// The bucket is created if it's missing and everything is kept under a
+// prefix of its own that's cleaned up afterwards
+func minioDriver(t *testing.T) *Driver {
+	t.Helper()
+
+	endpoint := os.Getenv("SCANTP_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("SCANTP_TEST_S3_ENDPOINT isn't set")
+	}
+	accessKey, secretKey := os.Getenv("SCANTP_TEST_S3_ACCESS_KEY"), os.Getenv("SCANTP_TEST_S3_SECRET_KEY")
+	bucket := os.Getenv("SCANTP_TEST_S3_BUCKET")
+	if bucket == "" {
+		bucket = "scantp-test"
+	}
+
+	client, err := minio.New(endpoint, accessKey, secretKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := client.BucketExists(bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		if err := client.MakeBucket(bucket, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prefix := fmt.Sprintf("test-%d/", time.Now().UnixNano())
+	t.Cleanup(func() {
+		done := make(chan struct{})
+		defer close(done)
+		for obj := range client.ListObjectsV2(bucket, prefix, true, done) {
+			if obj.Err == nil {
+				client.RemoveObject(bucket, obj.Key)
+			}
+		}
+	})
+
+	d, err := NewDriver(configure(fmt.Sprintf("endpoint = %q\nbucket = %q\nprefix = %q\naccess_key = %q\nsecret_key = %q\ninsecure = true",
+		endpoint, bucket, prefix, accessKey, secretKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func list(t *testing.T, d *Driver, p string) []string {
+	t.Helper()
+
+	var names []string
+	if err := d.ListDir(p, func(info server.FileInfo) error {
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestMinIO(t *testing.T) {
+	d := minioDriver(t)
+
+	if err := d.MakeDir("/2020"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.PutFile("/2020/scan.pdf", strings.NewReader("%PDF-1.4 scan"), false); err != nil || n != 13 {
+		t.Fatalf("put %d bytes: %v", n, err)
+	}
+	if _, err := d.PutFile("/2020/scan.pdf", strings.NewReader(" more"), true); err == nil {
+		t.Error("appended to an object")
+	}
+
+	info, err := d.Stat("/2020/scan.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.IsDir() || info.Size() != 13 || info.Name() != "scan.pdf" {
+		t.Errorf("stat gave %s, %d bytes, directory %v", info.Name(), info.Size(), info.IsDir())
+	}
+	if info, err := d.Stat("/2020"); err != nil || !info.IsDir() {
+		t.Errorf("directory stat: %v", err)
+	}
+	if _, err := d.Stat("/missing.pdf"); !os.IsNotExist(err) {
+		t.Errorf("missing file stat: %v", err)
+	}
+
+	if got := strings.Join(list(t, d, "/"), ","); got != "2020/" {
+		t.Errorf("root lists %s", got)
+	}
+	if got := strings.Join(list(t, d, "/2020"), ","); got != "scan.pdf" {
+		t.Errorf("directory lists %s", got)
+	}
+
+	if err := d.Rename("/2020/scan.pdf", "/2020/renamed.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat("/2020/scan.pdf"); !os.IsNotExist(err) {
+		t.Errorf("renamed file still there: %v", err)
+	}
+
+	obj, err := d.client.GetObject(d.configuration.Bucket, d.objectKey("/2020/renamed.pdf"), minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	if buf, err := ioutil.ReadAll(obj); err != nil || string(buf) != "%PDF-1.4 scan" {
+		t.Errorf("renamed object is %q: %v", buf, err)
+	}
+
+	if err := d.DeleteFile("/2020/renamed.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(list(t, d, "/2020"), ","); got != "" {
+		t.Errorf("after delete lists %s", got)
+	}
+}
@@ -0,0 +1,43 @@
+package s3
+
+import (
+	"os"
+	"time"
+)
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (f fileInfo) Name() string {
+	return f.name
+}
+func (f fileInfo) Size() int64 {
+	return f.size
+}
+func (f fileInfo) Mode() os.FileMode {
+	if f.isDir {
+		return os.ModeDir | 0777
+	}
+	return 0666
+}
+func (f fileInfo) ModTime() time.Time {
+	return f.modTime
+}
+func (f fileInfo) IsDir() bool {
+	return f.isDir
+}
+func (f fileInfo) Sys() interface{} {
+	return nil
+}
+
+func (f fileInfo) Owner() string {
+	return "nobody"
+}
+
+func (f fileInfo) Group() string {
+	return "nobody"
+}
@@ -15,6 +15,8 @@ import (
 	"time"
 
 	"goftp.io/server"
+
+	"github.com/freman/scantp/metrics"
 )
 
 func (d *Driver) getLibraries(ctx context.Context, fn func(server.FileInfo) error) ([]Library, error) {
@@ -79,6 +81,25 @@ func (d *Driver) getDirectoryDetail(ctx context.Context, libraryID, path string)
 	return &detail, nil
 }
 
+func (d *Driver) getFileDetail(ctx context.Context, libraryID, path string) (*fileDetail, error) {
+	query := url.Values{
+		"p": []string{path},
+	}.Encode()
+
+	uri := "api2/repos/" + libraryID + "/file/detail/?" + query
+	req, err := d.newRequest(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var detail fileDetail
+	if err := d.doRequest(req, jsonResponse(&detail, httpStatusOk)); err != nil {
+		return nil, err
+	}
+
+	return &detail, nil
+}
+
 func (d *Driver) listDirectoryEntries(ctx context.Context, libraryID, path string) ([]directoryEntry, error) {
 	query := url.Values{
 		"p": []string{path},
@@ -127,7 +148,7 @@ func (d *Driver) createDirectory(ctx context.Context, libraryID, path string) er
 		"p": []string{path},
 	}.Encode()
 
-	uri := "api2/repos/" + libraryID + "/dir/" + query
+	uri := "api2/repos/" + libraryID + "/dir/?" + query
 	req, err := d.newRequest(ctx, http.MethodPost, uri, strings.NewReader(form))
 	if err != nil {
 		return err
@@ -142,6 +163,52 @@ func (d *Driver) createDirectory(ctx context.Context, libraryID, path string) er
 	return nil
 }
 
+func (d *Driver) fileOperation(ctx context.Context, libraryID, path string, form url.Values) error {
+	query := url.Values{
+		"p": []string{path},
+	}.Encode()
+
+	uri := "api/v2.1/repos/" + libraryID + "/file/?" + query
+	req, err := d.newRequest(ctx, http.MethodPost, uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result json.RawMessage
+	return d.doRequest(req, jsonResponse(&result, httpStatusOk))
+}
+
+func (d *Driver) renameFile(ctx context.Context, libraryID, path, newName string) error {
+	return d.fileOperation(ctx, libraryID, path, url.Values{
+		"operation": []string{"rename"},
+		"newname":   []string{newName},
+	})
+}
+
+func (d *Driver) moveFile(ctx context.Context, libraryID, path, dstLibraryID, dstDir string) error {
+	return d.fileOperation(ctx, libraryID, path, url.Values{
+		"operation": []string{"move"},
+		"dst_repo":  []string{dstLibraryID},
+		"dst_dir":   []string{dstDir},
+	})
+}
+
+func (d *Driver) deleteFile(ctx context.Context, libraryID, path string) error {
+	query := url.Values{
+		"p": []string{path},
+	}.Encode()
+
+	uri := "api/v2.1/repos/" + libraryID + "/file/?" + query
+	req, err := d.newRequest(ctx, http.MethodDelete, uri, nil)
+	if err != nil {
+		return err
+	}
+
+	var result json.RawMessage
+	return d.doRequest(req, jsonResponse(&result, httpStatusOk))
+}
+
 func (d *Driver) resolveURL(path string) string {
 	u, err := url.Parse(path)
 	if err != nil {
@@ -167,7 +234,8 @@ func (d *Driver) newRequest(ctx context.Context, method string, url string, body
 		d.once.Do(func() {
 			if d.httpClient == nil {
 				d.httpClient = &http.Client{
-					Timeout: 10 * time.Second,
+					Timeout:   10 * time.Second,
+					Transport: metrics.SeafileTransport(http.DefaultTransport),
 				}
 			}
 
@@ -242,7 +310,22 @@ func (d *Driver) doRequest(r *http.Request, fn func(r *http.Response) error) err
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 		return fn(resp)
 	}
-	return fmt.Errorf("API http status error %d %s", resp.StatusCode, resp.Status)
+	return &statusError{code: resp.StatusCode, status: resp.Status}
+}
+
+// statusError is returned for api requests that didn't succeed, a 404
+// counts as os.ErrNotExist
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("API http status error %d %s", e.code, e.status)
+}
+
+func (e *statusError) Is(target error) bool {
+	return target == os.ErrNotExist && e.code == http.StatusNotFound
 }
 
 func (d *Driver) authenticate(ctx context.Context) error {
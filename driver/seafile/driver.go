@@ -1,7 +1,6 @@
 package seafile
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -9,6 +8,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -49,6 +49,9 @@ func (d *Driver) Stat(path string) (server.FileInfo, error) {
 	libraryName, subPath := libraryPrefix(path)
 	lib, err := d.getLibrary(context.TODO(), libraryName)
 	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+		}
 		return nil, err
 	}
 
@@ -56,8 +59,23 @@ func (d *Driver) Stat(path string) (server.FileInfo, error) {
 		return lib, nil
 	}
 
-	// TODO: stat files not just dirs?
-	return d.getDirectoryDetail(context.TODO(), lib.ID, subPath)
+	// Most things being stat'd are files that were just uploaded, so try that first
+	file, err := d.getFileDetail(context.TODO(), lib.ID, subPath)
+	if err == nil {
+		return file, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	dir, err := d.getDirectoryDetail(context.TODO(), lib.ID, subPath)
+	if err == nil {
+		return dir, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+	return nil, err
 }
 
 func (d *Driver) ListDir(path string, fn func(server.FileInfo) error) error {
@@ -124,34 +142,44 @@ func (d *Driver) PutFile(path string, stream io.Reader, abool bool) (int64, erro
 		return 0, err
 	}
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", subPath)
-	if err != nil {
-		return 0, err
-	}
-	sz, err := io.Copy(part, stream)
-	if err != nil {
-		return 0, err
-	}
-	if err := writer.WriteField("parent_dir", filepath.Dir(subPath)); err != nil {
-		return 0, err
-	}
-
-	err = writer.Close()
-	if err != nil {
-		return 0, err
-	}
+	// Stream the multipart body through a pipe so the upload is never held in
+	// memory, the size is only known once the copy has finished
+	body, pipe := io.Pipe()
+	writer := multipart.NewWriter(pipe)
+	copied := make(chan int64, 1)
+	go func() {
+		var sz int64
+		err := writer.WriteField("parent_dir", filepath.Dir(subPath))
+		if err == nil {
+			var part io.Writer
+			if part, err = writer.CreateFormFile("file", subPath); err == nil {
+				sz, err = io.Copy(part, stream)
+			}
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pipe.CloseWithError(err)
+		copied <- sz
+	}()
 
-	req, err := http.NewRequest(http.MethodPost, link+"?ret-json=1", body)
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, link+"?ret-json=1", body)
 	if err != nil {
+		body.CloseWithError(err)
+		<-copied
 		return 0, err
 	}
 	req.Header.Add("Authorization", "Token "+d.token)
 	req.Header.Add("Accept", "application/json; indent=4")
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	resp, err := http.DefaultClient.Do(req)
+	// Uploads can take far longer than the api timeout allows
+	client := *d.httpClient
+	client.Timeout = 0
+
+	resp, err := client.Do(req)
+	body.Close()
+	sz := <-copied
 	if err != nil {
 		return 0, err
 	}
@@ -163,6 +191,53 @@ func (d *Driver) PutFile(path string, stream io.Reader, abool bool) (int64, erro
 	return sz, nil
 }
 
+func (d *Driver) Rename(from, to string) error {
+	fromLibraryName, fromPath := libraryPrefix(from)
+	toLibraryName, toPath := libraryPrefix(to)
+	if fromPath == "" || toPath == "" {
+		return errors.New("Libraries can't be renamed")
+	}
+
+	fromLib, err := d.getLibrary(context.TODO(), fromLibraryName)
+	if err != nil {
+		return err
+	}
+
+	toLib, err := d.getLibrary(context.TODO(), toLibraryName)
+	if err != nil {
+		return err
+	}
+
+	// Seafile renames in place and moves keeping the name, so it can take both
+	if fromLib.ID != toLib.ID || filepath.Dir(fromPath) != filepath.Dir(toPath) {
+		if err := d.moveFile(context.TODO(), fromLib.ID, fromPath, toLib.ID, filepath.Dir(toPath)); err != nil {
+			return err
+		}
+		fromLib = toLib
+		fromPath = filepath.Join(filepath.Dir(toPath), filepath.Base(fromPath))
+	}
+
+	if filepath.Base(fromPath) == filepath.Base(toPath) {
+		return nil
+	}
+
+	return d.renameFile(context.TODO(), fromLib.ID, fromPath, filepath.Base(toPath))
+}
+
+func (d *Driver) DeleteFile(path string) error {
+	libraryName, realPath := libraryPrefix(path)
+	if realPath == "" {
+		return errors.New("Libraries can't be deleted")
+	}
+
+	lib, err := d.getLibrary(context.TODO(), libraryName)
+	if err != nil {
+		return err
+	}
+
+	return d.deleteFile(context.TODO(), lib.ID, realPath)
+}
+
 func NewDriver(fn func(v interface{}) error) (d *Driver, err error) {
 	d = &Driver{}
 	if err = fn(&d.configuration); err != nil {
@@ -0,0 +1,330 @@
+package seafile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+// fakeSeafile is just enough of the seafile api to upload, look up, rename
+// and delete files in a single library called docs
+type fakeSeafile struct {
+	t   *testing.T
+	url string
+
+	mu    sync.Mutex
+	files map[string]string
+	dirs  map[string]bool
+	// chunked is whether every upload was streamed rather than sent with
+	// its length up front
+	chunked bool
+	// operations are the file operations asked for, in order
+	operations []string
+}
+
+func newFakeSeafile(t *testing.T) *fakeSeafile {
+	f := &fakeSeafile{t: t, files: make(map[string]string), dirs: map[string]bool{"/": true}, chunked: true}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	f.url = srv.URL
+	return f
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeSeafile) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/api2/ping/":
+		reply(w, http.StatusOK, "pong")
+		return
+	case "/api2/auth-token/":
+		if r.FormValue("username") != "scanner" || r.FormValue("password") != "scanme" {
+			reply(w, http.StatusBadRequest, map[string]interface{}{"non_field_errors": []string{"Unable to login"}})
+			return
+		}
+		reply(w, http.StatusOK, map[string]string{"token": "t0k3n"})
+		return
+	case "/upload/abc":
+		f.upload(w, r)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Token t0k3n" {
+		reply(w, http.StatusUnauthorized, map[string]string{"detail": "Invalid token"})
+		return
+	}
+
+	p := r.URL.Query().Get("p")
+	switch r.Method + " " + r.URL.Path {
+	case "GET /api2/auth/ping/":
+		reply(w, http.StatusOK, "pong")
+	case "GET /api2/repos":
+		reply(w, http.StatusOK, []map[string]interface{}{{"name": "docs", "id": "repo1", "permission": "rw", "type": "repo"}})
+	case "GET /api2/repos/repo1/upload-link/":
+		reply(w, http.StatusOK, f.url+"/upload/abc")
+	case "GET /api2/repos/repo1/file/detail/":
+		content, isa := f.files[p]
+		if !isa {
+			reply(w, http.StatusNotFound, map[string]string{"error_msg": "File not found"})
+			return
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"id": "f1", "type": "file", "name": path.Base(p), "size": len(content), "mtime": 1600000000})
+	case "GET /api/v2.1/repos/repo1/dir/detail/":
+		dir := r.URL.Query().Get("path")
+		if !f.dirs[dir] {
+			reply(w, http.StatusNotFound, map[string]string{"error_msg": "Folder not found"})
+			return
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"repo_id": "repo1", "path": dir, "name": path.Base(dir), "mtime": "2020-09-13T12:26:40+00:00"})
+	case "POST /api2/repos/repo1/dir/":
+		f.dirs[p] = true
+		reply(w, http.StatusCreated, "success")
+	case "POST /api/v2.1/repos/repo1/file/":
+		f.operation(w, r, p)
+	case "DELETE /api/v2.1/repos/repo1/file/":
+		if _, isa := f.files[p]; !isa {
+			reply(w, http.StatusNotFound, map[string]string{"error_msg": "File not found"})
+			return
+		}
+		f.operations = append(f.operations, "delete "+p)
+		delete(f.files, p)
+		reply(w, http.StatusOK, map[string]bool{"success": true})
+	default:
+		reply(w, http.StatusNotFound, map[string]string{"error_msg": "no such endpoint " + r.Method + " " + r.URL.Path})
+	}
+}
+
+func (f *fakeSeafile) upload(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("ret-json") != "1" || r.Header.Get("Authorization") != "Token t0k3n" {
+		reply(w, http.StatusForbidden, map[string]string{"error": "bad upload"})
+		return
+	}
+	if r.ContentLength != -1 {
+		f.chunked = false
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		reply(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		reply(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	dir := r.FormValue("parent_dir")
+	if !f.dirs[dir] {
+		reply(w, http.StatusNotFound, map[string]string{"error": "Parent dir doesn't exist."})
+		return
+	}
+	f.files[path.Join(dir, path.Base(header.Filename))] = string(content)
+	reply(w, http.StatusOK, []map[string]interface{}{{"name": path.Base(header.Filename), "size": len(content)}})
+}
+
+func (f *fakeSeafile) operation(w http.ResponseWriter, r *http.Request, p string) {
+	content, isa := f.files[p]
+	if !isa {
+		reply(w, http.StatusNotFound, map[string]string{"error_msg": "File not found"})
+		return
+	}
+
+	var to string
+	switch r.FormValue("operation") {
+	case "rename":
+		to = path.Join(path.Dir(p), r.FormValue("newname"))
+	case "move":
+		if r.FormValue("dst_repo") != "repo1" || !f.dirs[r.FormValue("dst_dir")] {
+			reply(w, http.StatusBadRequest, map[string]string{"error_msg": "bad destination"})
+			return
+		}
+		to = path.Join(r.FormValue("dst_dir"), path.Base(p))
+	default:
+		reply(w, http.StatusBadRequest, map[string]string{"error_msg": "bad operation"})
+		return
+	}
+
+	f.operations = append(f.operations, fmt.Sprintf("%s %s %s", r.FormValue("operation"), p, to))
+	delete(f.files, p)
+	f.files[to] = content
+	reply(w, http.StatusOK, map[string]interface{}{"repo_id": "repo1", "parent_dir": path.Dir(to), "obj_name": path.Base(to)})
+}
+
+func (f *fakeSeafile) content(p string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, isa := f.files[p]
+	return content, isa
+}
+
+func newDriver(t *testing.T, f *fakeSeafile, password string) *Driver {
+	t.Helper()
+
+	d, err := NewDriver(func(v interface{}) error {
+		_, err := toml.Decode(fmt.Sprintf("username = \"scanner\"\npassword = %q\napi = %q", password, f.url+"/"), v)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestPutFile(t *testing.T) {
+	f := newFakeSeafile(t)
+	d := newDriver(t, f, "scanme")
+
+	if err := d.MakeDir("/docs/in"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := d.PutFile("/docs/in/scan.pdf", strings.NewReader("%PDF scanned"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 12 {
+		t.Errorf("put %d bytes", n)
+	}
+
+	if got, _ := f.content("/in/scan.pdf"); got != "%PDF scanned" {
+		t.Errorf("uploaded %q", got)
+	}
+	if !f.chunked {
+		t.Error("the upload wasn't streamed")
+	}
+
+	// Failing uploads say so
+	if _, err := d.PutFile("/docs/missing/scan.pdf", strings.NewReader("%PDF"), false); err == nil {
+		t.Error("upload into a missing folder succeeded")
+	}
+}
+
+// Files are looked up first, folders when there's no file by that name
+func TestStat(t *testing.T) {
+	f := newFakeSeafile(t)
+	f.files["/in/scan.pdf"] = "%PDF scanned"
+	f.dirs["/in"] = true
+	d := newDriver(t, f, "scanme")
+
+	tests := []struct {
+		path  string
+		name  string
+		isDir bool
+		size  int64
+	}{
+		{"/", "/", true, 0},
+		{"/docs", "docs", true, 0},
+		{"/docs/in/scan.pdf", "scan.pdf", false, 12},
+		{"/docs/in", "in", true, 0},
+	}
+	for _, test := range tests {
+		info, err := d.Stat(test.path)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if info.Name() != test.name || info.IsDir() != test.isDir || info.Size() != test.size {
+			t.Errorf("%s: got %s dir %v size %d", test.path, info.Name(), info.IsDir(), info.Size())
+		}
+	}
+
+	for _, missing := range []string{"/docs/in/other.pdf", "/other/scan.pdf"} {
+		if _, err := d.Stat(missing); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: got %v", missing, err)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	if err := error(&statusError{code: http.StatusNotFound, status: "404 Not Found"}); !errors.Is(err, os.ErrNotExist) {
+		t.Error("404 isn't os.ErrNotExist")
+	}
+	if err := error(&statusError{code: http.StatusInternalServerError, status: "500 Internal Server Error"}); errors.Is(err, os.ErrNotExist) {
+		t.Error("500 is os.ErrNotExist")
+	}
+}
+
+func TestRename(t *testing.T) {
+	f := newFakeSeafile(t)
+	f.dirs["/in"], f.dirs["/filed"] = true, true
+	d := newDriver(t, f, "scanme")
+
+	tests := []struct {
+		from, to   string
+		operations []string
+	}{
+		{"/in/a.pdf", "/in/b.pdf", []string{"rename /in/a.pdf /in/b.pdf"}},
+		{"/in/a.pdf", "/filed/a.pdf", []string{"move /in/a.pdf /filed/a.pdf"}},
+		{"/in/a.pdf", "/filed/b.pdf", []string{"move /in/a.pdf /filed/a.pdf", "rename /filed/a.pdf /filed/b.pdf"}},
+	}
+	for _, test := range tests {
+		f.mu.Lock()
+		f.files = map[string]string{test.from: "scanned"}
+		f.operations = nil
+		f.mu.Unlock()
+
+		if err := d.Rename("/docs"+test.from, "/docs"+test.to); err != nil {
+			t.Errorf("%s to %s: %v", test.from, test.to, err)
+			continue
+		}
+		if got, _ := f.content(test.to); got != "scanned" {
+			t.Errorf("%s to %s: ended up with %v", test.from, test.to, f.files)
+		}
+		if got := strings.Join(f.operations, ", "); got != strings.Join(test.operations, ", ") {
+			t.Errorf("%s to %s: did %s", test.from, test.to, got)
+		}
+	}
+
+	if err := d.Rename("/docs/in/missing.pdf", "/docs/in/other.pdf"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("renaming a missing file gave %v", err)
+	}
+	if err := d.Rename("/docs", "/archive"); err == nil {
+		t.Error("a library was renamed")
+	}
+}
+
+func TestDeleteFile(t *testing.T) {
+	f := newFakeSeafile(t)
+	f.files["/scan.pdf"] = "scanned"
+	d := newDriver(t, f, "scanme")
+
+	if err := d.DeleteFile("/docs/scan.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if _, isa := f.content("/scan.pdf"); isa {
+		t.Error("file is still there")
+	}
+	if err := d.DeleteFile("/docs/scan.pdf"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("deleting it again gave %v", err)
+	}
+	if err := d.DeleteFile("/docs"); err == nil {
+		t.Error("a library was deleted")
+	}
+}
+
+func TestLoginFails(t *testing.T) {
+	f := newFakeSeafile(t)
+	d := newDriver(t, f, "wrong")
+
+	if _, err := d.Stat("/docs/scan.pdf"); err == nil || !strings.Contains(err.Error(), "login process failed") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
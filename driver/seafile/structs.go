@@ -148,6 +148,39 @@ func (f directoryDetail) Group() string {
 	return ""
 }
 
+type fileDetail struct {
+	ID    string `json:"id"`
+	Mtime int64  `json:"mtime"`
+	Type  string `json:"type"`
+	FName string `json:"name"`
+	FSize int64  `json:"size"`
+}
+
+func (f fileDetail) Name() string {
+	return f.FName
+}
+func (f fileDetail) Size() int64 {
+	return f.FSize
+}
+func (f fileDetail) Mode() (m os.FileMode) {
+	return 0666
+}
+func (f fileDetail) ModTime() time.Time {
+	return time.Unix(f.Mtime, 0)
+}
+func (f fileDetail) IsDir() bool {
+	return false
+}
+func (f fileDetail) Sys() interface{} {
+	return nil
+}
+func (f fileDetail) Owner() string {
+	return ""
+}
+func (f fileDetail) Group() string {
+	return ""
+}
+
 type uploadLink struct {
 	Username   string    `json:"username"`
 	ViewCnt    int       `json:"view_cnt"`
@@ -0,0 +1,120 @@
+// Package sent keeps track of what drivers that hand uploads on rather than
+// keeping them have sent, so scanners that check an upload once it's done,
+// or change into the folder they upload to, find what they expect
+package sent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"goftp.io/server"
+)
+
+// For is how long sent files are remembered
+const For = time.Hour
+
+// Files are the files sent recently and the folders they went to
+type Files struct {
+	mu   sync.Mutex
+	sent map[string]*fileInfo
+	// dirs are the folders that have been made or sent to, they're
+	// remembered so scanners can change into them
+	dirs map[string]bool
+}
+
+func New() *Files {
+	return &Files{sent: make(map[string]*fileInfo), dirs: make(map[string]bool)}
+}
+
+// Dir describes a folder
+func Dir(name string) server.FileInfo {
+	return &fileInfo{name: name, isDir: true, mode: os.ModeDir | 0755}
+}
+
+// Stat finds a file sent within the last hour or a folder that's been used,
+// the root is always there
+func (f *Files) Stat(p string) (server.FileInfo, error) {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return Dir("/"), nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if info, isa := f.sent[p]; isa {
+		return info, nil
+	}
+	if f.dirs[p] {
+		return Dir(path.Base(p)), nil
+	}
+
+	return nil, fmt.Errorf("%s: %w", p, os.ErrNotExist)
+}
+
+// Remember records a file as sent, along with the folder it was sent to.
+// Files sent longer ago than For are forgotten
+func (f *Files) Remember(p string, size int64) {
+	p = path.Clean("/" + p)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for k, info := range f.sent {
+		if now.Sub(info.modTime) > For {
+			delete(f.sent, k)
+		}
+	}
+	f.sent[p] = &fileInfo{name: path.Base(p), size: size, mode: 0644, modTime: now}
+	f.addDir(path.Dir(p))
+}
+
+// AddDir remembers a folder along with its parents
+func (f *Files) AddDir(p string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addDir(path.Clean("/" + p))
+}
+
+// addDir does the work of AddDir, f.mu must be held
+func (f *Files) addDir(p string) {
+	for ; p != "/" && p != "."; p = path.Dir(p) {
+		f.dirs[p] = true
+	}
+}
+
+// Rename only applies to the record of what was sent, whatever it was sent
+// to has it under the old name
+func (f *Files) Rename(from, to string) error {
+	from, to = path.Clean("/"+from), path.Clean("/"+to)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, isa := f.sent[from]
+	if !isa {
+		return fmt.Errorf("%s: %w", from, os.ErrNotExist)
+	}
+	delete(f.sent, from)
+	info.name = path.Base(to)
+	f.sent[to] = info
+	f.addDir(path.Dir(to))
+	return nil
+}
+
+// CountingReader counts what's read through it, which is how much of an
+// upload was sent
+type CountingReader struct {
+	io.Reader
+	N int64
+}
+
+func (r *CountingReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.N += int64(n)
+	return n, err
+}
@@ -0,0 +1,81 @@
+package sent
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFiles(t *testing.T) {
+	f := New()
+	f.Remember("in/scan.pdf", 4)
+	f.AddDir("/made/deep")
+
+	tests := []struct {
+		path  string
+		isDir bool
+		size  int64
+	}{
+		{"/", true, 0},
+		{"/in", true, 0},
+		{"/in/scan.pdf", false, 4},
+		{"/made", true, 0},
+		{"/made/deep", true, 0},
+	}
+	for _, test := range tests {
+		info, err := f.Stat(test.path)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if info.IsDir() != test.isDir || info.Size() != test.size {
+			t.Errorf("%s: dir %v size %d", test.path, info.IsDir(), info.Size())
+		}
+	}
+
+	if _, err := f.Stat("/other.pdf"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unsent file gave %v", err)
+	}
+}
+
+func TestForget(t *testing.T) {
+	f := New()
+	f.Remember("/old.pdf", 1)
+	f.sent["/old.pdf"].modTime = time.Now().Add(-For - time.Minute)
+
+	f.Remember("/new.pdf", 1)
+	if _, err := f.Stat("/old.pdf"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("old file is still remembered: %v", err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	f := New()
+	f.Remember("/scan.pdf", 4)
+
+	if err := f.Rename("/scan.pdf", "/filed/scan.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := f.Stat("/filed/scan.pdf"); err != nil || info.Name() != "scan.pdf" {
+		t.Errorf("renamed file gave %v, %v", info, err)
+	}
+	if _, err := f.Stat("/scan.pdf"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("old name gave %v", err)
+	}
+	if err := f.Rename("/missing.pdf", "/x.pdf"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("renaming a missing file gave %v", err)
+	}
+}
+
+func TestCountingReader(t *testing.T) {
+	r := &CountingReader{Reader: strings.NewReader("scanned")}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if r.N != 7 {
+		t.Errorf("counted %d bytes", r.N)
+	}
+}
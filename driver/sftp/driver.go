@@ -0,0 +1,243 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"goftp.io/server"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type Driver struct {
+	sshConfig *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *sftp.Client
+
+	configuration struct {
+		Address    string `toml:"address"`
+		Username   string `toml:"username"`
+		Password   string `toml:"password"`
+		PrivateKey string `toml:"private_key"`
+		Passphrase string `toml:"passphrase"`
+		HostKey    string `toml:"host_key"`
+		Root       string `toml:"root"`
+		Timeout    string `toml:"timeout"`
+	}
+}
+
+// remotePath maps a path within the driver to a path on the remote host
+func (d *Driver) remotePath(p string) string {
+	return path.Join(d.configuration.Root, p)
+}
+
+// getClient returns the current sftp client, connecting if there isn't one
+// or the last one was lost
+func (d *Driver) getClient() (*sftp.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	conn, err := ssh.Dial("tcp", d.configuration.Address, d.sshConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %w", d.configuration.Address, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to start sftp session: %w", err)
+	}
+
+	go func() {
+		client.Wait()
+		conn.Close()
+
+		d.mu.Lock()
+		if d.client == client {
+			d.client = nil
+		}
+		d.mu.Unlock()
+	}()
+
+	d.client = client
+	return client, nil
+}
+
+func (d *Driver) Stat(p string) (server.FileInfo, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := client.Stat(d.remotePath(p))
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{FileInfo: info, name: path.Base(p)}, nil
+}
+
+func (d *Driver) ListDir(p string, fn func(server.FileInfo) error) error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	entries, err := client.ReadDir(d.remotePath(p))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := fn(&fileInfo{FileInfo: entry}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Driver) MakeDir(p string) error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	return client.MkdirAll(d.remotePath(p))
+}
+
+func (d *Driver) PutFile(p string, stream io.Reader, appendData bool) (int64, error) {
+	client, err := d.getClient()
+	if err != nil {
+		return 0, err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendData {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	f, err := client.OpenFile(d.remotePath(p), flags)
+	if err != nil {
+		return 0, err
+	}
+
+	// The client writes from its own offset whatever the flags say, so
+	// appends have to start from the end themselves
+	if appendData {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return 0, err
+		}
+	}
+
+	n, err := f.ReadFrom(stream)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return n, err
+}
+
+func (d *Driver) Rename(from, to string) error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	return client.Rename(d.remotePath(from), d.remotePath(to))
+}
+
+func (d *Driver) DeleteFile(p string) error {
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	return client.Remove(d.remotePath(p))
+}
+
+func parseHostKey(hostKey string) (ssh.PublicKey, error) {
+	if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey)); err == nil {
+		return key, nil
+	}
+
+	// Also accept lines straight out of known_hosts or ssh-keyscan
+	_, _, key, _, _, err := ssh.ParseKnownHosts([]byte(hostKey))
+	return key, err
+}
+
+func NewDriver(fn func(v interface{}) error) (d *Driver, err error) {
+	d = &Driver{}
+	d.configuration.Root = "/"
+	if err = fn(&d.configuration); err != nil {
+		return nil, err
+	}
+
+	if d.configuration.Address == "" || d.configuration.Username == "" || d.configuration.HostKey == "" {
+		return nil, errors.New("Configuration for sftp is invalid, required address, username and host_key")
+	}
+
+	if d.configuration.Password == "" && d.configuration.PrivateKey == "" {
+		return nil, errors.New("Configuration for sftp is invalid, required password or private_key")
+	}
+
+	hostKey, err := parseHostKey(d.configuration.HostKey)
+	if err != nil {
+		return nil, fmt.Errorf("failure while parsing host_key: %w", err)
+	}
+
+	timeout := defaultTimeout
+	if d.configuration.Timeout != "" {
+		if timeout, err = time.ParseDuration(d.configuration.Timeout); err != nil {
+			return nil, fmt.Errorf("failure while parsing sftp timeout: %w", err)
+		}
+	}
+
+	var auth []ssh.AuthMethod
+	if d.configuration.PrivateKey != "" {
+		pem, err := ioutil.ReadFile(d.configuration.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failure while reading private_key: %w", err)
+		}
+
+		var signer ssh.Signer
+		if d.configuration.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(d.configuration.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failure while parsing private_key: %w", err)
+		}
+
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if d.configuration.Password != "" {
+		auth = append(auth, ssh.Password(d.configuration.Password))
+	}
+
+	d.sshConfig = &ssh.ClientConfig{
+		User:              d.configuration.Username,
+		Auth:              auth,
+		HostKeyCallback:   ssh.FixedHostKey(hostKey),
+		HostKeyAlgorithms: []string{hostKey.Type()},
+		Timeout:           timeout,
+	}
+
+	return d, nil
+}
@@ -0,0 +1,284 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/sftp"
+	"goftp.io/server"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshServer is an in process ssh server that only speaks sftp
+type sshServer struct {
+	addr    string
+	hostKey string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newSSHServer(t *testing.T) *sshServer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "scanner" && string(password) == "scanme" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("wrong password for %s", conn.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &sshServer{
+		addr:    l.Addr().String(),
+		hostKey: string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+	}
+	t.Cleanup(func() {
+		l.Close()
+		s.drop()
+	})
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go s.serve(conn, config)
+		}
+	}()
+
+	return s
+}
+
+func (s *sshServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				// The payload is the length prefixed subsystem name
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+			}
+		}(requests)
+
+		go func() {
+			defer channel.Close()
+			if srv, err := sftp.NewServer(channel); err == nil {
+				srv.Serve()
+			}
+		}()
+	}
+}
+
+// drop hangs up on every client, like a server restarting would
+func (s *sshServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func configure(config string) func(v interface{}) error {
+	return func(v interface{}) error {
+		_, err := toml.Decode(config, v)
+		return err
+	}
+}
+
+func driver(t *testing.T, s *sshServer, root, hostKey string) *Driver {
+	t.Helper()
+
+	d, err := NewDriver(configure(fmt.Sprintf("address = %q\nusername = \"scanner\"\npassword = \"scanme\"\nhost_key = %q\nroot = %q\ntimeout = \"5s\"",
+		s.addr, strings.TrimSpace(hostKey), filepath.ToSlash(root))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.mu.Lock()
+		if d.client != nil {
+			d.client.Close()
+		}
+		d.mu.Unlock()
+	})
+	return d
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "scantp-sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func list(t *testing.T, d *Driver, p string) string {
+	t.Helper()
+
+	var names []string
+	if err := d.ListDir(p, func(info server.FileInfo) error {
+		names = append(names, info.Name())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestDriver(t *testing.T) {
+	s, root := newSSHServer(t), tempDir(t)
+	d := driver(t, s, root, s.hostKey)
+
+	if err := d.MakeDir("/2020/01"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.PutFile("/2020/01/scan.pdf", strings.NewReader("%PDF-1.4"), false); err != nil || n != 8 {
+		t.Fatalf("put %d bytes: %v", n, err)
+	}
+	if _, err := d.PutFile("/2020/01/scan.pdf", strings.NewReader(" more"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(root, "2020", "01", "scan.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "%PDF-1.4 more" {
+		t.Errorf("wrote %q", buf)
+	}
+
+	info, err := d.Stat("/2020/01/scan.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "scan.pdf" || info.Size() != 13 || info.IsDir() {
+		t.Errorf("stat gave %s, %d bytes, directory %v", info.Name(), info.Size(), info.IsDir())
+	}
+	if got := list(t, d, "/2020/01"); got != "scan.pdf" {
+		t.Errorf("listed %s", got)
+	}
+
+	if err := d.Rename("/2020/01/scan.pdf", "/2020/01/renamed.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(t, d, "/2020/01"); got != "renamed.pdf" {
+		t.Errorf("after rename listed %s", got)
+	}
+
+	if err := d.DeleteFile("/2020/01/renamed.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(t, d, "/2020/01"); got != "" {
+		t.Errorf("after delete listed %s", got)
+	}
+}
+
+func TestWrongHostKey(t *testing.T) {
+	s, other := newSSHServer(t), newSSHServer(t)
+	d := driver(t, s, tempDir(t), other.hostKey)
+
+	if _, err := d.Stat("/"); err == nil {
+		t.Error("connected to a server with the wrong host key")
+	}
+}
+
+// A dropped connection is dialled again on the next request
+func TestReconnect(t *testing.T) {
+	s, root := newSSHServer(t), tempDir(t)
+	d := driver(t, s, root, s.hostKey)
+
+	if _, err := d.Stat("/"); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	client := d.client
+	d.mu.Unlock()
+
+	s.drop()
+	client.Wait()
+
+	// The driver notices the client is gone in the background
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		d.mu.Lock()
+		gone := d.client != client
+		d.mu.Unlock()
+		if gone {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dropped connection was never noticed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := d.PutFile("/scan.pdf", strings.NewReader("%PDF"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "scan.pdf")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConfiguration(t *testing.T) {
+	tests := map[string]string{
+		"no host key": "address = \"localhost:22\"\nusername = \"scanner\"\npassword = \"scanme\"",
+		"no auth":     "address = \"localhost:22\"\nusername = \"scanner\"\nhost_key = \"ssh-ed25519 AAAA\"",
+		"bad key":     "address = \"localhost:22\"\nusername = \"scanner\"\npassword = \"scanme\"\nhost_key = \"nonsense\"",
+	}
+
+	for name, config := range tests {
+		if _, err := NewDriver(configure(config)); err == nil {
+			t.Errorf("%s: configuration was accepted", name)
+		}
+	}
+}
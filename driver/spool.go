@@ -0,0 +1,473 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freman/scantp/driver/retry"
+	"github.com/freman/scantp/metrics"
+	"github.com/sirupsen/logrus"
+	"goftp.io/server"
+)
+
+const (
+	spoolInitialBackoff = 5 * time.Second
+	spoolDefaultMaxAge  = 24 * time.Hour
+	spoolIdleWait       = time.Hour
+	spoolFailedDir      = "failed"
+)
+
+// spoolJob is an upload waiting in the spool, it's persisted next to the
+// data as json so the queue survives restarts
+type spoolJob struct {
+	id string
+	source
+	Path        string    `json:"path"`
+	Append      bool      `json:"append"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// spool accepts uploads onto local disk straight away and delivers them in
+// the background, retrying with exponential backoff until they arrive or
+// get too old
+type spool struct {
+	name       string
+	dir        string
+	maxBackoff time.Duration
+	// maxAge is how long to keep retrying a file, zero retries forever
+	maxAge  time.Duration
+	deliver func(source, string, io.Reader, bool) (int64, error)
+	// failed is told about files the spool has given up on
+	failed func(source, string, error)
+
+	mu     sync.Mutex
+	jobs   []*spoolJob
+	active *spoolJob
+	idle   *sync.Cond
+	seq    uint64
+	wake   chan struct{}
+}
+
+func newSpool(name, dir string, maxBackoff, maxAge time.Duration, deliver func(source, string, io.Reader, bool) (int64, error), failed func(source, string, error)) (*spool, error) {
+	s := &spool{
+		name:       name,
+		dir:        dir,
+		maxBackoff: maxBackoff,
+		maxAge:     maxAge,
+		deliver:    deliver,
+		failed:     failed,
+		wake:       make(chan struct{}, 1),
+	}
+	s.idle = sync.NewCond(&s.mu)
+
+	if err := os.MkdirAll(filepath.Join(dir, spoolFailedDir), 0700); err != nil {
+		return nil, err
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if len(s.jobs) > 0 {
+		s.log().Infof("resuming delivery of %d files", len(s.jobs))
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+func (s *spool) log() *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{"path": s.name, "component": "spool"})
+}
+
+func (s *spool) giveUp(job *spoolJob, err error) {
+	metrics.Failure(s.name, "gave_up")
+	if s.failed != nil {
+		s.failed(job.source, job.Path, err)
+	}
+}
+
+func (s *spool) dataFile(id string) string {
+	return filepath.Join(s.dir, id+".data")
+}
+
+func (s *spool) jobFile(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// load picks up the jobs left over from last time, data without a job file
+// never finished uploading so it's thrown away
+func (s *spool) load() error {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	jobs := make(map[string]bool)
+	for _, entry := range entries {
+		if id := strings.TrimSuffix(entry.Name(), ".json"); id != entry.Name() {
+			jobs[id] = true
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		id := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if !jobs[id] {
+			os.Remove(filepath.Join(s.dir, entry.Name()))
+			continue
+		}
+
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		buf, err := ioutil.ReadFile(s.jobFile(id))
+		if err != nil {
+			return err
+		}
+
+		job := &spoolJob{id: id}
+		if err := json.Unmarshal(buf, job); err != nil {
+			return fmt.Errorf("unable to read spooled job %s: %w", id, err)
+		}
+
+		s.jobs = append(s.jobs, job)
+	}
+
+	sort.Slice(s.jobs, func(i, j int) bool {
+		return s.jobs[i].id < s.jobs[j].id
+	})
+
+	return nil
+}
+
+// save writes the job file, the rename means it's either all there or not
+// there at all
+func (s *spool) save(job *spoolJob) error {
+	buf, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.jobFile(job.id) + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.jobFile(job.id))
+}
+
+func (s *spool) nextID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	return fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), s.seq%1000000)
+}
+
+// PutFile writes the upload into the spool and queues it for delivery
+func (s *spool) PutFile(src source, p string, data io.Reader, appendData bool) (int64, error) {
+	job := &spoolJob{
+		id:      s.nextID(),
+		source:  src,
+		Path:    p,
+		Append:  appendData,
+		Created: time.Now(),
+	}
+
+	f, err := os.OpenFile(s.dataFile(job.id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	job.Size, err = io.Copy(f, data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.save(job)
+	}
+	if err != nil {
+		os.Remove(s.dataFile(job.id))
+		return 0, err
+	}
+
+	s.mu.Lock()
+	s.jobs = append(s.jobs, job)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return job.Size, nil
+}
+
+// stat reports on a file that is still waiting in the spool
+func (s *spool) stat(match pending) (server.FileInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.jobs) - 1; i >= 0; i-- {
+		if job := s.jobs[i]; match(job.source, job.Path) {
+			return &spooledFile{name: path.Base(job.Path), size: job.Size, modTime: job.Created}, true
+		}
+	}
+
+	return nil, false
+}
+
+// depth is how many files are waiting to be delivered
+func (s *spool) depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs)
+}
+
+func (s *spool) run() {
+	timer := time.NewTimer(0)
+	for {
+		job, wait := s.next()
+		if job != nil {
+			s.attempt(job)
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// next finds the oldest job that is due and marks it active, or how long
+// until one will be. Jobs wait for earlier ones to the same file, so appends
+// land on the file they belong to and an old retry can't overwrite a newer
+// upload
+func (s *spool) next() (*spoolJob, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	wait := spoolIdleWait
+	earlier := make(map[string]bool)
+	for _, job := range s.jobs {
+		if earlier[job.Path] {
+			continue
+		}
+		earlier[job.Path] = true
+
+		if !job.NextAttempt.After(now) {
+			s.active = job
+			return job, 0
+		}
+		if until := job.NextAttempt.Sub(now); until < wait {
+			wait = until
+		}
+	}
+
+	return nil, wait
+}
+
+func (s *spool) attempt(job *spoolJob) {
+	defer s.setActive(nil)
+
+	f, err := os.Open(s.dataFile(job.id))
+	if err != nil {
+		s.log().WithField("file", job.Path).WithError(err).Error("dropping file, unable to open spooled data")
+		s.remove(job)
+		s.giveUp(job, err)
+		return
+	}
+
+	_, err = s.deliver(job.source, job.Path, f, job.Append)
+	f.Close()
+
+	if err == nil {
+		s.log().WithFields(logrus.Fields{"file": job.Path, "attempts": job.Attempts + 1}).Info("delivered")
+		s.remove(job)
+		return
+	}
+
+	if errors.Is(err, retry.ErrPermanent) || (s.maxAge > 0 && time.Since(job.Created) >= s.maxAge) {
+		s.log().WithFields(logrus.Fields{"file": job.Path, "attempts": job.Attempts + 1, "moved_to": spoolFailedDir}).WithError(err).Error("giving up on delivery")
+		s.fail(job)
+		s.giveUp(job, err)
+		return
+	}
+
+	job.Attempts++
+	job.LastError = err.Error()
+	job.NextAttempt = time.Now().Add(s.backoff(job.Attempts))
+	if serr := s.save(job); serr != nil {
+		s.log().WithField("file", job.Path).WithError(serr).Error("unable to save state")
+	}
+
+	s.log().WithFields(logrus.Fields{
+		"file":     job.Path,
+		"attempts": job.Attempts,
+		"retry_at": job.NextAttempt.Format(time.RFC3339),
+	}).WithError(err).Warn("delivery failed")
+}
+
+// setActive tracks the job being delivered so it isn't renamed or deleted
+// underneath us
+func (s *spool) setActive(job *spoolJob) {
+	s.mu.Lock()
+	s.active = job
+	s.mu.Unlock()
+	s.idle.Broadcast()
+}
+
+// rename changes where files still waiting in the spool will be delivered,
+// if one is in the middle of being delivered this waits for that to finish
+// and leaves it to the driver
+func (s *spool) rename(match pending, to string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.active != nil && match(s.active.source, s.active.Path) {
+		s.idle.Wait()
+	}
+
+	found := false
+	for _, job := range s.jobs {
+		if match(job.source, job.Path) {
+			job.Path = to
+			if err := s.save(job); err != nil {
+				s.log().WithField("file", job.Path).WithError(err).Error("unable to save state")
+			}
+			found = true
+		}
+	}
+
+	return found
+}
+
+// delete drops files that are still waiting in the spool, like rename it
+// waits for a delivery in progress and leaves it to the driver
+func (s *spool) delete(match pending) bool {
+	s.mu.Lock()
+	for s.active != nil && match(s.active.source, s.active.Path) {
+		s.idle.Wait()
+	}
+
+	var found []*spoolJob
+	kept := s.jobs[:0]
+	for _, job := range s.jobs {
+		if match(job.source, job.Path) {
+			found = append(found, job)
+			continue
+		}
+		kept = append(kept, job)
+	}
+	s.jobs = kept
+	s.mu.Unlock()
+
+	for _, job := range found {
+		os.Remove(s.jobFile(job.id))
+		os.Remove(s.dataFile(job.id))
+	}
+	return len(found) > 0
+}
+
+func (s *spool) backoff(attempts int) time.Duration {
+	backoff := spoolInitialBackoff
+	for i := 1; i < attempts && backoff < s.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > s.maxBackoff {
+		return s.maxBackoff
+	}
+	return backoff
+}
+
+func (s *spool) forget(job *spoolJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, v := range s.jobs {
+		if v == job {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *spool) remove(job *spoolJob) {
+	s.forget(job)
+	os.Remove(s.jobFile(job.id))
+	os.Remove(s.dataFile(job.id))
+}
+
+// fail keeps the files around for a human to look at
+func (s *spool) fail(job *spoolJob) {
+	s.forget(job)
+	failed := filepath.Join(s.dir, spoolFailedDir)
+	os.Rename(s.dataFile(job.id), filepath.Join(failed, job.id+".data"))
+	os.Rename(s.jobFile(job.id), filepath.Join(failed, job.id+".json"))
+}
+
+type spooledFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (f spooledFile) Name() string {
+	return f.name
+}
+func (f spooledFile) Size() int64 {
+	return f.size
+}
+func (f spooledFile) Mode() os.FileMode {
+	return 0444
+}
+func (f spooledFile) ModTime() time.Time {
+	return f.modTime
+}
+func (f spooledFile) IsDir() bool {
+	return false
+}
+func (f spooledFile) Sys() interface{} {
+	return nil
+}
+
+func (f spooledFile) Owner() string {
+	return "spool"
+}
+
+func (f spooledFile) Group() string {
+	return "spool"
+}
@@ -0,0 +1,168 @@
+package driver
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deliveries records what a spool delivered, failing the first attempts at
+// each file it's told to
+type deliveries struct {
+	mu    sync.Mutex
+	got   []string
+	fails map[string]int
+}
+
+func (d *deliveries) deliver(src source, realPath string, data io.Reader, appendData bool) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.fails[realPath] > 0 {
+		d.fails[realPath]--
+		return 0, errors.New("destination is down")
+	}
+
+	buf, err := ioutil.ReadAll(data)
+	if err != nil {
+		return 0, err
+	}
+	d.got = append(d.got, realPath+":"+string(buf))
+	return int64(len(buf)), nil
+}
+
+func (d *deliveries) delivered() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.got...)
+}
+
+// emptied waits for the spool to have nothing left to do
+func emptied(t *testing.T, s *spool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); s.depth() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("spool never emptied")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func put(t *testing.T, s *spool, name, data string, appendData bool) {
+	t.Helper()
+
+	if _, err := s.PutFile(source{}, name, strings.NewReader(data), appendData); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// An append waits for the upload it belongs to, even while that's being
+// retried, other files carry on
+func TestSpoolOrder(t *testing.T) {
+	d := &deliveries{fails: map[string]int{"/scan.pdf": 2}}
+	s, err := newSpool("test", tempDir(t), time.Millisecond, 0, d.deliver, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put(t, s, "/scan.pdf", "first", false)
+	put(t, s, "/scan.pdf", "second", true)
+	put(t, s, "/other.pdf", "other", false)
+	emptied(t, s)
+
+	got := strings.Join(d.delivered(), ",")
+	if !strings.Contains(got, "/scan.pdf:first,/scan.pdf:second") {
+		t.Errorf("delivered %s", got)
+	}
+	if !strings.Contains(got, "/other.pdf:other") {
+		t.Errorf("/other.pdf wasn't delivered, got %s", got)
+	}
+}
+
+// Whatever was left waiting is delivered in order after a restart
+func TestSpoolRestart(t *testing.T) {
+	dir := tempDir(t)
+
+	down := &deliveries{fails: map[string]int{"/scan.pdf": 1}}
+	s, err := newSpool("test", dir, time.Hour, 0, down.deliver, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "/scan.pdf", "first", false)
+	put(t, s, "/scan.pdf", "second", false)
+
+	// Wait for the first attempt to fail, leaving both waiting an hour
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		s.mu.Lock()
+		waiting := s.active == nil && s.jobs[0].Attempts == 1
+		s.mu.Unlock()
+		if waiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first attempt never failed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	up := &deliveries{}
+	restarted, err := newSpool("test", dir, time.Hour, 0, up.deliver, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.depth(); got != 2 {
+		t.Fatalf("reloaded %d files", got)
+	}
+
+	// The failed attempt is waiting for its backoff, so push it along
+	restarted.mu.Lock()
+	restarted.jobs[0].NextAttempt = time.Time{}
+	restarted.mu.Unlock()
+	select {
+	case restarted.wake <- struct{}{}:
+	default:
+	}
+	emptied(t, restarted)
+
+	if got := strings.Join(up.delivered(), ","); got != "/scan.pdf:first,/scan.pdf:second" {
+		t.Errorf("delivered %s", got)
+	}
+}
+
+func TestSpoolMaxAge(t *testing.T) {
+	dir := tempDir(t)
+	d := &deliveries{fails: map[string]int{"/scan.pdf": 1000}}
+
+	gaveUp := make(chan string, 1)
+	s, err := newSpool("test", dir, time.Millisecond, 50*time.Millisecond, d.deliver, func(src source, realPath string, err error) {
+		gaveUp <- realPath
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "/scan.pdf", "never", false)
+
+	select {
+	case got := <-gaveUp:
+		if got != "/scan.pdf" {
+			t.Errorf("gave up on %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("never gave up")
+	}
+	emptied(t, s)
+
+	failed, err := filepath.Glob(filepath.Join(dir, spoolFailedDir, "*.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 {
+		t.Errorf("%d files in failed", len(failed))
+	}
+}
@@ -0,0 +1,136 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateData is what filename_template and directory_template have to
+// work with
+type templateData struct {
+	// Path is the name of the virtual path
+	Path string
+	// User is the logged in user
+	User string
+	// Original is the name the file was uploaded with, Name and Ext are
+	// the same split at the extension
+	Original string
+	Name     string
+	Ext      string
+	// Dir is the directory the file was uploaded into
+	Dir string
+
+	Now                                    time.Time
+	Year, Month, Day, Hour, Minute, Second string
+	Date, Time                             string
+}
+
+func parseTemplate(option, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New(option).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", option, err)
+	}
+
+	return tmpl, nil
+}
+
+// destination works out where an upload should be written by applying the
+// templates, paths without templates are written where they were uploaded
+func (vp *virtualPath) destination(realPath, user string, now time.Time) (string, error) {
+	if vp.filenameTemplate == nil && vp.directoryTemplate == nil {
+		return realPath, nil
+	}
+
+	dir, original := path.Split(realPath)
+	ext := path.Ext(original)
+	data := templateData{
+		Path:     vp.name,
+		User:     user,
+		Original: original,
+		Name:     strings.TrimSuffix(original, ext),
+		Ext:      ext,
+		Dir:      dir,
+		Now:      now,
+		Year:     now.Format("2006"),
+		Month:    now.Format("01"),
+		Day:      now.Format("02"),
+		Hour:     now.Format("15"),
+		Minute:   now.Format("04"),
+		Second:   now.Format("05"),
+		Date:     now.Format("2006-01-02"),
+		Time:     now.Format("150405"),
+	}
+
+	if vp.directoryTemplate != nil {
+		var sb strings.Builder
+		if err := vp.directoryTemplate.Execute(&sb, data); err != nil {
+			return "", err
+		}
+		dir = sb.String()
+	}
+
+	name := original
+	if vp.filenameTemplate != nil {
+		var sb strings.Builder
+		if err := vp.filenameTemplate.Execute(&sb, data); err != nil {
+			return "", err
+		}
+		name = sb.String()
+	}
+
+	// Templates can only move files around inside the virtual path
+	for _, part := range strings.Split(dir+"/"+name, "/") {
+		if part == ".." {
+			return "", errors.New("templates can't refer to parent directories")
+		}
+	}
+
+	destPath := path.Join("/", dir, name)
+	if destPath == "/" || strings.HasSuffix(name, "/") {
+		return "", fmt.Errorf("template gave %q which isn't a file name", destPath)
+	}
+
+	return destPath, nil
+}
+
+// makeParents creates any directories above realPath that don't exist yet
+func (vp *virtualPath) makeParents(realPath string) error {
+	dir := path.Dir(realPath)
+	if dir == "/" || dir == "." {
+		return nil
+	}
+
+	info, err := vp.Driver.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := vp.makeParents(dir); err != nil {
+		return err
+	}
+
+	if err := vp.Driver.MakeDir(dir); err != nil {
+		// Another upload may have got there first
+		if info, serr := vp.Driver.Stat(dir); serr == nil && info.IsDir() {
+			return nil
+		}
+		return fmt.Errorf("unable to create %s: %w", dir, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func templated(t *testing.T, filename, directory string) *virtualPath {
+	t.Helper()
+
+	vp := &virtualPath{name: "docs"}
+	var err error
+	if vp.filenameTemplate, err = parseTemplate("filename_template", filename); err != nil {
+		t.Fatal(err)
+	}
+	if vp.directoryTemplate, err = parseTemplate("directory_template", directory); err != nil {
+		t.Fatal(err)
+	}
+	return vp
+}
+
+func TestDestination(t *testing.T) {
+	now := time.Date(2020, 3, 14, 9, 26, 53, 0, time.UTC)
+
+	tests := []struct {
+		filename, directory, upload, want string
+	}{
+		{"", "", "/in/scan.pdf", "/in/scan.pdf"},
+		{"{{.User}}-{{.Date}}-{{.Time}}{{.Ext}}", "", "/in/scan.pdf", "/in/alice-2020-03-14-092653.pdf"},
+		{"", "{{.Year}}/{{.Month}}", "/in/scan.pdf", "/2020/03/scan.pdf"},
+		{"{{.Name}}-{{.Day}}{{.Hour}}{{.Minute}}{{.Second}}{{.Ext}}", "{{.Dir}}{{.Path}}", "/in/scan.pdf", "/in/docs/scan-14092653.pdf"},
+		{`{{.Now.Format "Jan 2006"}} {{.Original}}`, "/", "/in/scan.pdf", "/Mar 2020 scan.pdf"},
+	}
+
+	for _, test := range tests {
+		got, err := templated(t, test.filename, test.directory).destination(test.upload, "alice", now)
+		if err != nil {
+			t.Errorf("%q %q: %v", test.filename, test.directory, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q %q gave %s, expected %s", test.filename, test.directory, got, test.want)
+		}
+	}
+}
+
+func TestDestinationRefused(t *testing.T) {
+	tests := []struct {
+		filename, directory string
+	}{
+		// Escaping the path
+		{"", "../{{.User}}"},
+		{"../{{.Original}}", ""},
+		// Not leaving a file name
+		{"{{.Dir}}", "/"},
+		{"{{.User}}/", ""},
+		// Failing to execute
+		{"{{.Missing}}", ""},
+	}
+
+	for _, test := range tests {
+		if got, err := templated(t, test.filename, test.directory).destination("/scan.pdf", "", time.Now()); err == nil {
+			t.Errorf("%q %q gave %s", test.filename, test.directory, got)
+		}
+	}
+
+	if _, err := parseTemplate("filename_template", "{{.User"); err == nil {
+		t.Error("broken template was parsed")
+	}
+}
+
+// Uploads land in the directories the templates name, which are created if
+// they're missing
+func TestTemplatedUpload(t *testing.T) {
+	root := tempDir(t)
+	factory := configured(t, nil, `
+[path.docs]
+type = "fs"
+root = "`+root+`"
+directory_template = "{{.Path}}/{{.Name}}"
+filename_template = "copy{{.Ext}}"
+`)
+
+	d := session(t, factory)
+	if _, err := d.PutFile("/docs/scan.pdf", strings.NewReader("mine"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := content(t, filepath.Join(root, "docs", "scan", "copy.pdf")); got != "mine" {
+		t.Errorf("upload is %q", got)
+	}
+	if info, err := d.Stat("/docs/scan.pdf"); err != nil || info.Size() != 4 {
+		t.Errorf("stat of the uploaded name: %v", err)
+	}
+}
@@ -0,0 +1,490 @@
+package driver
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/freman/scantp/driver/notify"
+	"github.com/freman/scantp/driver/processor"
+	"github.com/freman/scantp/metrics"
+	"github.com/sirupsen/logrus"
+	"goftp.io/server"
+)
+
+const defaultSpoolMaxBackoff = time.Hour
+
+// virtualPath is a driver along with the options that apply to every path
+// regardless of the driver
+type virtualPath struct {
+	Driver
+	name        string
+	driverName  string
+	onConflict  conflictPolicy
+	allowRename renamePolicy
+	allowDelete deletePolicy
+	deleteAfter time.Duration
+	processors  processor.Chain
+	notifiers   notify.List
+	merger      *merger
+	spool       *spool
+	// route finds the path a processor sent a document to, as long as the
+	// user who uploaded it may use it
+	route func(name, user string) (*virtualPath, bool)
+
+	filenameTemplate  *template.Template
+	directoryTemplate *template.Template
+}
+
+type pathOptions struct {
+	OnConflict   string `toml:"on_conflict"`
+	AllowRename  string `toml:"allow_rename"`
+	AllowDelete  string `toml:"allow_delete"`
+	DeleteWindow string `toml:"delete_window"`
+
+	FilenameTemplate  string `toml:"filename_template"`
+	DirectoryTemplate string `toml:"directory_template"`
+
+	Spool           string `toml:"spool"`
+	SpoolMaxBackoff string `toml:"spool_max_backoff"`
+	SpoolMaxAge     string `toml:"spool_max_age"`
+
+	Pipeline            []string `toml:"pipeline"`
+	PipelineMatch       string   `toml:"pipeline_match"`
+	PipelineTimeout     string   `toml:"pipeline_timeout"`
+	PipelineConcurrency int      `toml:"pipeline_concurrency"`
+
+	Merge        string   `toml:"merge"`
+	MergeMatch   []string `toml:"merge_match"`
+	MergePattern string   `toml:"merge_pattern"`
+	MergeTimeout string   `toml:"merge_timeout"`
+	MergeDPI     float64  `toml:"merge_dpi"`
+
+	Processors []toml.Primitive `toml:"processor"`
+	Notify     []toml.Primitive `toml:"notify"`
+}
+
+// source is who an upload came from, it follows the upload through the
+// merger, spool and processors to wherever it's finally written
+type source struct {
+	User    string `json:"user,omitempty"`
+	Session string `json:"session,omitempty"`
+	// RoutedFrom is the path a processor sent the upload on from, routed
+	// uploads aren't routed again
+	RoutedFrom string `json:"routed_from,omitempty"`
+
+	// upload is told where the file was written, it's only there while the
+	// session that uploaded it might still want to know
+	upload *upload
+}
+
+// uploadedAt is when the upload started, or now if that's been forgotten
+// like it is after a restart
+func (src source) uploadedAt() time.Time {
+	if src.upload != nil {
+		return src.upload.at
+	}
+	return time.Now()
+}
+
+// pending picks out files that are still waiting in the merger or spool
+type pending func(src source, realPath string) bool
+
+// pendingPath matches files waiting to be written to realPath
+func pendingPath(realPath string) pending {
+	return func(_ source, p string) bool {
+		return p == realPath
+	}
+}
+
+// pendingUpload matches the files an upload is still waiting as
+func pendingUpload(u *upload) pending {
+	return func(src source, _ string) bool {
+		return src.upload == u
+	}
+}
+
+func newVirtualPath(name string, subDriver Driver, options pathOptions, processors processor.Chain, notifiers notify.List) (vp *virtualPath, err error) {
+	vp = &virtualPath{
+		Driver:     subDriver,
+		name:       name,
+		processors: processors,
+		notifiers:  notifiers,
+	}
+
+	// Processors can take a while and merging holds pages until the next
+	// one turns up, neither should keep the scanner waiting
+	if options.Spool == "" && (len(processors) > 0 || len(options.Pipeline) > 0 || options.Merge != "") {
+		return nil, fmt.Errorf("%s needs a spool to run processors, a pipeline or merge pages", name)
+	}
+
+	if vp.onConflict, err = parseConflictPolicy(options.OnConflict); err != nil {
+		return nil, err
+	}
+
+	if vp.allowRename, err = parseRenamePolicy(options.AllowRename); err != nil {
+		return nil, err
+	}
+
+	if vp.allowDelete, err = parseDeletePolicy(options.AllowDelete); err != nil {
+		return nil, err
+	}
+
+	if options.DeleteWindow != "" {
+		if vp.deleteAfter, err = time.ParseDuration(options.DeleteWindow); err != nil {
+			return nil, fmt.Errorf("failure while parsing delete_window: %w", err)
+		}
+	}
+
+	if vp.filenameTemplate, err = parseTemplate("filename_template", options.FilenameTemplate); err != nil {
+		return nil, err
+	}
+
+	if vp.directoryTemplate, err = parseTemplate("directory_template", options.DirectoryTemplate); err != nil {
+		return nil, err
+	}
+
+	// pipeline is shorthand for an exec processor that runs before the rest
+	if len(options.Pipeline) > 0 {
+		var p processor.Processor
+		if p, err = processor.NewExec(processor.ExecConfig{
+			Command:     options.Pipeline,
+			Timeout:     options.PipelineTimeout,
+			Concurrency: options.PipelineConcurrency,
+		}); err != nil {
+			return nil, fmt.Errorf("invalid pipeline for %s: %w", name, err)
+		}
+
+		if options.PipelineMatch != "" {
+			if p, err = processor.Match(options.PipelineMatch, p); err != nil {
+				return nil, fmt.Errorf("invalid pipeline_match for %s: %w", name, err)
+			}
+		}
+
+		vp.processors = append(processor.Chain{p}, vp.processors...)
+	}
+
+	maxBackoff := defaultSpoolMaxBackoff
+	if options.SpoolMaxBackoff != "" {
+		if maxBackoff, err = time.ParseDuration(options.SpoolMaxBackoff); err != nil {
+			return nil, fmt.Errorf("failure while parsing spool_max_backoff: %w", err)
+		}
+	}
+
+	maxAge := spoolDefaultMaxAge
+	if options.SpoolMaxAge != "" {
+		if maxAge, err = time.ParseDuration(options.SpoolMaxAge); err != nil {
+			return nil, fmt.Errorf("failure while parsing spool_max_age: %w", err)
+		}
+	}
+
+	if options.Spool != "" {
+		if vp.spool, err = newSpool(name, options.Spool, maxBackoff, maxAge, vp.deliver, vp.failed); err != nil {
+			return nil, fmt.Errorf("unable to start spool for %s: %w", name, err)
+		}
+		metrics.SpoolDepth(name, vp.spool.depth)
+	}
+
+	// Merged documents are queued like any other upload, so the spool has to
+	// be running before pages left over from last time are merged
+	if options.Merge != "" {
+		if vp.merger, err = newMerger(name, options, maxBackoff, maxAge, func(src source, realPath string, data io.Reader) error {
+			_, err := vp.queue(src, realPath, data, false)
+			return err
+		}); err != nil {
+			return nil, fmt.Errorf("unable to merge for %s: %w", name, err)
+		}
+		vp.merger.failed = vp.failed
+	}
+
+	return vp, nil
+}
+
+// failed tells the notifiers about files that were accepted but couldn't be
+// delivered later on
+func (vp *virtualPath) failed(src source, realPath string, err error) {
+	vp.notifiers.Notify(notify.Event{Path: vp.name, File: realPath, User: src.User, Session: src.Session, Error: err.Error()})
+}
+
+// stat looks in the spool for files still waiting to be delivered before
+// asking the driver
+func (vp *virtualPath) stat(realPath string) (server.FileInfo, error) {
+	if info, isa := vp.pending(pendingPath(realPath)); isa {
+		return info, nil
+	}
+
+	return vp.Driver.Stat(realPath)
+}
+
+// pending reports on a file that is still waiting to be merged or delivered
+func (vp *virtualPath) pending(match pending) (server.FileInfo, bool) {
+	if vp.merger != nil {
+		if info, isa := vp.merger.stat(match); isa {
+			return info, true
+		}
+	}
+
+	if vp.spool != nil {
+		if info, isa := vp.spool.stat(match); isa {
+			return info, true
+		}
+	}
+
+	return nil, false
+}
+
+// put holds on to images that are being merged, everything else is queued
+// for delivery
+func (vp *virtualPath) put(session string, src source, realPath string, data io.Reader, appendData bool) (int64, error) {
+	if vp.merger != nil && !appendData {
+		if n, isa, err := vp.merger.add(session, src, realPath, data); isa {
+			return n, err
+		}
+	}
+
+	return vp.queue(src, realPath, data, appendData)
+}
+
+// queue hands the upload to the spool if there is one, otherwise it goes
+// straight to the driver
+func (vp *virtualPath) queue(src source, realPath string, data io.Reader, appendData bool) (int64, error) {
+	if vp.spool != nil {
+		return vp.spool.PutFile(src, realPath, data, appendData)
+	}
+
+	return vp.deliver(src, realPath, data, appendData)
+}
+
+// deliver runs the upload through the processors and writes whatever comes
+// out the other end. Documents a processor sent to another path are queued
+// there, going through its spool and processors like any other upload. The
+// size returned is that of the original upload since that's what the
+// scanner sent
+func (vp *virtualPath) deliver(src source, realPath string, data io.Reader, appendData bool) (int64, error) {
+	// Appending to a processed file makes no sense so those go through as is
+	if len(vp.processors) == 0 || appendData {
+		return vp.write(src, realPath, data, appendData)
+	}
+
+	doc, size, err := processor.NewDocument(vp.name, realPath, data)
+	if err != nil {
+		return 0, err
+	}
+	defer doc.Cleanup()
+	doc.User = src.User
+
+	docs, err := vp.processors.Run(doc)
+	if err != nil {
+		metrics.Failure(vp.name, "processor")
+		return 0, err
+	}
+
+	for _, doc := range docs {
+		target := vp
+		if doc.VirtualPath != vp.name {
+			target = vp.routed(src, doc)
+		}
+
+		f, err := doc.Open()
+		if err != nil {
+			return 0, err
+		}
+
+		if target == vp {
+			_, err = vp.write(src, doc.Path, f, false)
+		} else {
+			// The path it was sent to names its files its own way
+			var realPath string
+			if realPath, err = target.destination(doc.Path, src.User, src.uploadedAt()); err == nil {
+				_, err = target.queue(source{User: src.User, Session: src.Session, RoutedFrom: vp.name, upload: src.upload}, realPath, f, false)
+			}
+		}
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return size, nil
+}
+
+// routed finds the path a processor sent a document to. Documents stay here
+// if the path doesn't exist, if the user who uploaded it isn't allowed to
+// use it or if it's already been routed once
+func (vp *virtualPath) routed(src source, doc *processor.Document) *virtualPath {
+	entry := logrus.WithFields(logrus.Fields{"path": vp.name, "file": doc.Path, "route": doc.VirtualPath})
+
+	if src.RoutedFrom != "" {
+		entry.WithField("routed_from", src.RoutedFrom).Warn("already routed once, keeping it here")
+		return vp
+	}
+
+	target, exists := vp.route(doc.VirtualPath, src.User)
+	if !exists {
+		entry.WithField("user", src.User).Warn("no path to route to, keeping it here")
+		return vp
+	}
+
+	return target
+}
+
+// write applies the conflict policy and writes with the driver, templates
+// can send files into directories that don't exist yet so they're created.
+// Where the file was written is passed back to the session that uploaded it,
+// which carries on appending to the same file. Notifiers hear about it here
+// once it's really been delivered, failures are left to whoever called
+// since the spool retries them
+func (vp *virtualPath) write(src source, realPath string, data io.Reader, appendData bool) (int64, error) {
+	if written, isa := src.upload.in(vp); appendData && isa {
+		realPath = written
+	} else {
+		if vp.filenameTemplate != nil || vp.directoryTemplate != nil {
+			if err := vp.makeParents(realPath); err != nil {
+				return 0, err
+			}
+		}
+
+		var err error
+		if realPath, err = vp.resolveConflict(realPath, appendData); err != nil {
+			metrics.Failure(vp.name, "conflict")
+			return 0, err
+		}
+	}
+
+	started := time.Now()
+	var n int64
+	var err error
+	if d, isa := vp.Driver.(UserDriver); isa {
+		n, err = d.PutFileAs(vp.name, src.User, realPath, data, appendData)
+	} else {
+		n, err = vp.Driver.PutFile(realPath, data, appendData)
+	}
+	metrics.Upload(vp.name, vp.driverName, started, n, err)
+	if err != nil {
+		return n, err
+	}
+
+	src.upload.wrote(vp, realPath)
+	vp.notifiers.Notify(notify.Event{Path: vp.name, File: realPath, User: src.User, Session: src.Session, Bytes: n})
+	return n, nil
+}
+
+// rename moves a file within the path, anything still waiting in the spool
+// is renamed there before it's delivered. The conflict policy applies to the
+// new name just like it does to uploads, the name it ended up with is
+// returned
+func (vp *virtualPath) rename(from, to string) (string, error) {
+	if vp.renamePending(pendingPath(from), to) {
+		return to, nil
+	}
+
+	return vp.move(from, to)
+}
+
+// move renames a file that's been written, applying the conflict policy to
+// the new name
+func (vp *virtualPath) move(from, to string) (string, error) {
+	to, err := vp.resolveConflict(to, false)
+	if err != nil {
+		return "", err
+	}
+
+	return to, vp.Driver.Rename(from, to)
+}
+
+// renamePending renames files still waiting to be merged or delivered
+func (vp *virtualPath) renamePending(match pending, to string) bool {
+	if vp.merger != nil && vp.merger.rename(match, to) {
+		return true
+	}
+
+	return vp.spool != nil && vp.spool.rename(match, to)
+}
+
+// cancel drops files still waiting to be merged or delivered
+func (vp *virtualPath) cancel(match pending) bool {
+	if vp.merger != nil && vp.merger.delete(match) {
+		return true
+	}
+
+	return vp.spool != nil && vp.spool.delete(match)
+}
+
+// statUpload reports on a file uploaded by a session, whether it's still
+// waiting to be delivered or where it was written
+func (vp *virtualPath) statUpload(u *upload) (server.FileInfo, error) {
+	if info, isa := vp.pending(pendingUpload(u)); isa {
+		return info, nil
+	}
+
+	if realPath, isa := u.in(vp); isa {
+		return vp.Driver.Stat(realPath)
+	}
+
+	for _, f := range u.written() {
+		return f.vp.Driver.Stat(f.realPath)
+	}
+
+	return nil, fmt.Errorf("%s: %w", u.realPath, os.ErrNotExist)
+}
+
+// renameUpload renames a file uploaded by a session, whether it's still
+// waiting to be delivered or where it was written. Uploads a processor split
+// up or sent to another path can't be renamed
+func (vp *virtualPath) renameUpload(u *upload, to string) error {
+	if vp.renamePending(pendingUpload(u), to) {
+		u.realPath = to
+		return nil
+	}
+
+	files := u.written()
+	switch {
+	case len(files) == 0:
+		return fmt.Errorf("%s was never written: %w", u.realPath, os.ErrNotExist)
+	case len(files) > 1:
+		return fmt.Errorf("Permission Denied, file was split into %d", len(files))
+	case files[0].vp != vp:
+		return fmt.Errorf("Permission Denied, file was sent on to %s", files[0].vp.name)
+	}
+
+	moved, err := vp.move(files[0].realPath, to)
+	if err != nil {
+		return err
+	}
+
+	u.moved(vp, files[0].realPath, moved)
+	u.realPath = to
+	return nil
+}
+
+// deleteUpload deletes a file uploaded by a session, whatever is still
+// waiting to be delivered is dropped and the rest deleted from wherever it
+// was written. Files a processor sent to another path are only deleted if
+// that path allows it too
+func (vp *virtualPath) deleteUpload(u *upload) error {
+	// Cancelling waits for a delivery in progress, so after it the files
+	// written are all there will be
+	cancelled := vp.cancel(pendingUpload(u))
+
+	files := u.written()
+	if !cancelled && len(files) == 0 {
+		return fmt.Errorf("%s was never written: %w", u.realPath, os.ErrNotExist)
+	}
+
+	for _, f := range files {
+		if f.vp.allowDelete != deleteSession {
+			return fmt.Errorf("Permission Denied, file was sent on to %s", f.vp.name)
+		}
+	}
+
+	for _, f := range files {
+		if err := f.vp.Driver.DeleteFile(f.realPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
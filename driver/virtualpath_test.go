@@ -0,0 +1,280 @@
+package driver
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/freman/scantp/driver/pdf"
+	"github.com/freman/scantp/driver/processor"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// allowed lets each user use the paths listed for them
+type allowed map[string][]string
+
+func (a allowed) Allowed(username, pathName string) bool {
+	for _, name := range a[username] {
+		if name == pathName {
+			return true
+		}
+	}
+	return false
+}
+
+// tempDir makes a directory that's removed when the test is done
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "scantp-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.ToSlash(dir)
+}
+
+// configured builds a factory from the [path.*] tables in config
+func configured(t *testing.T, authorizer Authorizer, config string) *MultipleDriverFactory {
+	t.Helper()
+
+	var c struct {
+		Paths map[string]toml.Primitive `toml:"path"`
+	}
+	md, err := toml.Decode(config, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factory := &MultipleDriverFactory{Authorizer: authorizer}
+	for name, prim := range c.Paths {
+		var tmp struct {
+			Type string `toml:"type"`
+		}
+		if err := md.PrimitiveDecode(prim, &tmp); err != nil {
+			t.Fatal(err)
+		}
+		if err := factory.AddPath(name, tmp.Type, md, prim); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return factory
+}
+
+// routeTo sends every document to another path, like a cover sheet would
+type routeTo string
+
+func (r routeTo) Process(doc *processor.Document) ([]*processor.Document, error) {
+	routed := doc.With(doc.Path, doc.File)
+	routed.VirtualPath = string(r)
+	return []*processor.Document{routed}, nil
+}
+
+func exists(t *testing.T, name string) bool {
+	t.Helper()
+
+	_, err := os.Stat(name)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestRoutingChecksUser(t *testing.T) {
+	docs, archive := tempDir(t), tempDir(t)
+	factory := configured(t, allowed{"alice": {"docs", "archive"}, "bob": {"docs"}}, `
+[path.docs]
+type = "fs"
+root = "`+docs+`"
+
+[path.archive]
+type = "fs"
+root = "`+archive+`"
+`)
+	factory.drivers["docs"].processors = processor.Chain{routeTo("archive")}
+
+	tests := []struct {
+		user, file string
+		routed     bool
+	}{
+		{"alice", "/alice.pdf", true},
+		{"bob", "/bob.pdf", false},
+		{"", "/nobody.pdf", false},
+	}
+
+	for _, test := range tests {
+		if _, err := factory.drivers["docs"].deliver(source{User: test.user}, test.file, strings.NewReader("%PDF"), false); err != nil {
+			t.Fatalf("%s: %v", test.user, err)
+		}
+
+		if got := exists(t, filepath.Join(archive, test.file)); got != test.routed {
+			t.Errorf("%s: routed to archive %v, expected %v", test.user, got, test.routed)
+		}
+		if got := exists(t, filepath.Join(docs, test.file)); got == test.routed {
+			t.Errorf("%s: kept in docs %v, expected %v", test.user, got, !test.routed)
+		}
+	}
+}
+
+func TestRoutingOnlyOnce(t *testing.T) {
+	a, b := tempDir(t), tempDir(t)
+	factory := configured(t, nil, `
+[path.a]
+type = "fs"
+root = "`+a+`"
+
+[path.b]
+type = "fs"
+root = "`+b+`"
+`)
+	// Each path sends everything to the other, which would never end
+	factory.drivers["a"].processors = processor.Chain{routeTo("b")}
+	factory.drivers["b"].processors = processor.Chain{routeTo("a")}
+
+	if _, err := factory.drivers["a"].deliver(source{}, "/scan.pdf", strings.NewReader("%PDF"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if !exists(t, filepath.Join(b, "scan.pdf")) {
+		t.Error("wasn't routed to b")
+	}
+	if exists(t, filepath.Join(a, "scan.pdf")) {
+		t.Error("was routed back to a")
+	}
+}
+
+func TestProcessingNeedsSpool(t *testing.T) {
+	root := tempDir(t)
+	tests := map[string]string{
+		"processor": "[[path.docs.processor]]\ntype = \"gzip\"",
+		"pipeline":  "pipeline = [\"true\"]",
+		"merge":     "merge = \"session\"",
+	}
+
+	for name, config := range tests {
+		var c struct {
+			Paths map[string]toml.Primitive `toml:"path"`
+		}
+		md, err := toml.Decode("[path.docs]\ntype = \"fs\"\nroot = \""+root+"\"\n"+config, &c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		factory := &MultipleDriverFactory{}
+		if err := factory.AddPath("docs", "fs", md, c.Paths["docs"]); err == nil {
+			t.Errorf("%s: accepted without a spool", name)
+		}
+	}
+}
+
+// Documents routed to another path are named by that path's templates
+func TestRoutingAppliesTemplates(t *testing.T) {
+	docs, archive := tempDir(t), tempDir(t)
+	factory := configured(t, nil, `
+[path.docs]
+type = "fs"
+root = "`+docs+`"
+filename_template = "{{.User}}-{{.Name}}{{.Ext}}"
+
+[path.archive]
+type = "fs"
+root = "`+archive+`"
+directory_template = "{{.User}}"
+filename_template = "filed-{{.Name}}{{.Ext}}"
+`)
+	factory.drivers["docs"].processors = processor.Chain{routeTo("archive")}
+
+	if _, err := factory.drivers["docs"].deliver(source{User: "alice"}, "/alice-scan.pdf", strings.NewReader("%PDF"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := content(t, filepath.Join(archive, "alice", "filed-alice-scan.pdf")); got != "%PDF" {
+		t.Errorf("routed document is %q", got)
+	}
+}
+
+// qrPage is a scanned page with nothing but a QR code saying text on it
+func qrPage(t *testing.T, text string) *pdf.Image {
+	t.Helper()
+
+	matrix, err := qrcode.NewQRCodeWriter().Encode(text, gozxing.BarcodeFormat_QR_CODE, 200, 200, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, matrix.GetWidth(), matrix.GetHeight()))
+	for y := 0; y < matrix.GetHeight(); y++ {
+		for x := 0; x < matrix.GetWidth(); x++ {
+			if !matrix.Get(x, y) {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	encoded, err := pdf.Encode(img, 72)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+// A batch of scans split on separator sheets ends up in the paths the
+// sheets name, under the names those paths give them
+func TestBarcodeSplitDelivery(t *testing.T) {
+	scans, spool, invoices := tempDir(t), tempDir(t), tempDir(t)
+	factory := configured(t, nil, `
+[path.scans]
+type = "fs"
+root = "`+scans+`"
+spool = "`+spool+`"
+
+[[path.scans.processor]]
+type = "barcode"
+split = true
+
+[path.invoices]
+type = "fs"
+root = "`+invoices+`"
+filename_template = "invoice-{{.Name}}{{.Ext}}"
+`)
+
+	var buf bytes.Buffer
+	w := pdf.NewWriter(&buf)
+	for _, text := range []string{"scans", "invoices"} {
+		if err := w.AddPage(qrPage(t, "not/a separator")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddPage(qrPage(t, text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.AddPage(qrPage(t, "not/a separator")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := session(t, factory)
+	if _, err := d.PutFile("/scans/batch.pdf", &buf, false); err != nil {
+		t.Fatal(err)
+	}
+	delivered(t, factory.drivers["scans"])
+
+	for _, name := range []string{filepath.Join(scans, "batch-1.pdf"), filepath.Join(scans, "batch-2.pdf"), filepath.Join(invoices, "invoice-batch-3.pdf")} {
+		if !exists(t, name) {
+			t.Errorf("%s wasn't delivered", name)
+		}
+	}
+	if exists(t, filepath.Join(scans, "batch.pdf")) {
+		t.Error("the batch was delivered whole")
+	}
+}
@@ -0,0 +1,157 @@
+package webdav
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/freman/scantp/driver/sent"
+	"github.com/studio-b12/gowebdav"
+	"goftp.io/server"
+)
+
+const (
+	defaultTimeout = 10 * time.Minute
+)
+
+type Driver struct {
+	client        *gowebdav.Client
+	httpClient    *http.Client
+	authorization string
+
+	configuration struct {
+		URL      string `toml:"url"`
+		Username string `toml:"username"`
+		Password string `toml:"password"`
+		Token    string `toml:"token"`
+		Timeout  string `toml:"timeout"`
+	}
+}
+
+func (d *Driver) Stat(p string) (server.FileInfo, error) {
+	info, err := d.client.Stat(p)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%s: %w", p, os.ErrNotExist)
+		}
+		return nil, err
+	}
+
+	return &fileInfo{FileInfo: info, name: path.Base(p)}, nil
+}
+
+func (d *Driver) ListDir(p string, fn func(server.FileInfo) error) error {
+	entries, err := d.client.ReadDir(p)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := fn(&fileInfo{FileInfo: entry}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Driver) MakeDir(p string) error {
+	return d.client.MkdirAll(p, 0755)
+}
+
+// PutFile streams the upload straight to the server, gowebdav's own
+// WriteStream keeps a copy of the whole body in memory in case it has
+// to retry after negotiating authentication
+func (d *Driver) PutFile(p string, stream io.Reader, appendData bool) (int64, error) {
+	if appendData {
+		return 0, errors.New("Appending to files is not supported")
+	}
+
+	// Only the path is escaped, the url is already as the server wants it
+	counter := &sent.CountingReader{Reader: stream}
+	req, err := http.NewRequest(http.MethodPut, gowebdav.Join(d.configuration.URL, gowebdav.PathEscape(p)), counter)
+	if err != nil {
+		return 0, err
+	}
+	if d.authorization != "" {
+		req.Header.Set("Authorization", d.authorization)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
+		return 0, fmt.Errorf("PUT %s failed: %s", p, resp.Status)
+	}
+
+	return counter.N, nil
+}
+
+func (d *Driver) Rename(from, to string) error {
+	return d.client.Rename(from, to, false)
+}
+
+func (d *Driver) DeleteFile(p string) error {
+	return d.client.Remove(p)
+}
+
+func isNotFound(err error) bool {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return strings.HasPrefix(pathErr.Err.Error(), "404")
+	}
+	return false
+}
+
+func NewDriver(fn func(v interface{}) error) (d *Driver, err error) {
+	d = &Driver{}
+	if err = fn(&d.configuration); err != nil {
+		return nil, err
+	}
+
+	if d.configuration.URL == "" {
+		return nil, errors.New("Configuration for webdav is invalid, required url")
+	}
+
+	if _, err := url.Parse(d.configuration.URL); err != nil {
+		return nil, fmt.Errorf(`failure while parsing webdav url: %w`, err)
+	}
+
+	if d.configuration.Token != "" && d.configuration.Username != "" {
+		return nil, errors.New("Configuration for webdav is invalid, use either username and password or token, not both")
+	}
+
+	// Authorization is sent up front rather than negotiated so the same
+	// header can be reused for the streaming uploads
+	if d.configuration.Token != "" {
+		d.authorization = "Bearer " + d.configuration.Token
+	} else if d.configuration.Username != "" {
+		d.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(d.configuration.Username+":"+d.configuration.Password))
+	}
+
+	timeout := defaultTimeout
+	if d.configuration.Timeout != "" {
+		if timeout, err = time.ParseDuration(d.configuration.Timeout); err != nil {
+			return nil, fmt.Errorf("failure while parsing timeout: %w", err)
+		}
+	}
+
+	d.httpClient = &http.Client{Timeout: timeout}
+	d.client = gowebdav.NewClient(d.configuration.URL, "", "")
+	d.client.SetTimeout(timeout)
+	if d.authorization != "" {
+		d.client.SetHeader("Authorization", d.authorization)
+	}
+
+	return d, nil
+}
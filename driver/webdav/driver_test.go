@@ -0,0 +1,106 @@
+package webdav
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func configure(config string) func(v interface{}) error {
+	return func(v interface{}) error {
+		_, err := toml.Decode(config, v)
+		return err
+	}
+}
+
+func TestPutFileEscaping(t *testing.T) {
+	type put struct {
+		path, body, authorization string
+	}
+	puts := make(chan put, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "unexpected "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		puts <- put{r.URL.EscapedPath(), string(body), r.Header.Get("Authorization")}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		url, file, want string
+	}{
+		{srv.URL + "/dav/", "/scan.pdf", "/dav/scan.pdf"},
+		{srv.URL + "/remote%20dav", "/scan.pdf", "/remote%20dav/scan.pdf"},
+		{srv.URL + "/dav", "/2020/a scan #1.pdf", "/dav/2020/a%20scan%20%231.pdf"},
+	}
+
+	for _, test := range tests {
+		d, err := NewDriver(configure(fmt.Sprintf("url = %q\ntoken = \"secret\"", test.url)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := d.PutFile(test.file, strings.NewReader("scan"), false)
+		if err != nil {
+			t.Errorf("%s%s: %v", test.url, test.file, err)
+			continue
+		}
+		if n != 4 {
+			t.Errorf("%s%s: wrote %d bytes, expected 4", test.url, test.file, n)
+		}
+
+		got := <-puts
+		if got.path != test.want {
+			t.Errorf("%s%s: put to %s, expected %s", test.url, test.file, got.path, test.want)
+		}
+		if got.body != "scan" || got.authorization != "Bearer secret" {
+			t.Errorf("%s%s: put %q with %q", test.url, test.file, got.body, got.authorization)
+		}
+	}
+}
+
+func TestPutFileAppend(t *testing.T) {
+	d := &Driver{}
+	if _, err := d.PutFile("/scan.pdf", strings.NewReader(""), true); err == nil {
+		t.Error("append succeeded")
+	}
+}
+
+// Servers that stop answering don't hold uploads up forever
+func TestTimeout(t *testing.T) {
+	stalled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer srv.Close()
+	defer close(stalled)
+
+	d, err := NewDriver(configure(fmt.Sprintf("url = %q\ntimeout = \"50ms\"", srv.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := d.PutFile("/scan.pdf", strings.NewReader("scan"), false); err == nil {
+		t.Error("upload succeeded")
+	}
+	if _, err := d.Stat("/scan.pdf"); err == nil {
+		t.Error("stat succeeded")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("took too long to give up")
+	}
+
+	if _, err := NewDriver(configure(fmt.Sprintf("url = %q\ntimeout = \"soon\"", srv.URL))); err == nil {
+		t.Error("bad timeout was accepted")
+	}
+}
@@ -0,0 +1,27 @@
+package webdav
+
+import (
+	"os"
+)
+
+// fileInfo decorates the os.FileInfo returned by gowebdav with what the ftp
+// server needs
+type fileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (f fileInfo) Name() string {
+	if f.name != "" {
+		return f.name
+	}
+	return f.FileInfo.Name()
+}
+
+func (f fileInfo) Owner() string {
+	return "nobody"
+}
+
+func (f fileInfo) Group() string {
+	return "nobody"
+}
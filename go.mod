@@ -6,10 +6,15 @@ replace goftp.io/server => ../../../gitea.com/freman/goftp-server
 
 require (
 	github.com/BurntSushi/toml v0.3.1
-	github.com/jlaffaye/ftp v0.0.0-20190624084859-c1312a7102bf
+	github.com/jlaffaye/ftp v0.0.0-20200812143550-39e3779af0db
+	github.com/makiuchi-d/gozxing v0.0.0-20210324052758-57132e828831
 	github.com/minio/minio-go/v6 v6.0.46
-	github.com/stretchr/testify v1.3.0
+	github.com/pkg/sftp v1.12.0
+	github.com/prometheus/client_golang v1.7.1
+	github.com/sirupsen/logrus v1.4.2
+	github.com/stretchr/testify v1.6.1
 	github.com/studio-b12/gowebdav v0.0.0-20200303150724-9380631c29a1
 	goftp.io/server v0.3.3
-	golang.org/x/crypto v0.0.0-20190701094942-4def268fd1a4
+	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+	golang.org/x/image v0.0.0-20200927104501-e162460cd6b5
 )
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"goftp.io/server"
+)
+
+// configureLogging sets the level and format of everything logged
+func (c configuration) configureLogging() error {
+	if c.LogLevel != "" {
+		level, err := logrus.ParseLevel(c.LogLevel)
+		if err != nil {
+			return fmt.Errorf("failure while parsing log_level: %w", err)
+		}
+		logrus.SetLevel(level)
+	}
+
+	switch c.LogFormat {
+	case "", "logfmt", "text":
+		logrus.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log_format %q, expected logfmt or json", c.LogFormat)
+	}
+
+	return nil
+}
+
+// ftpLogger sends the ftp server's own logging through logrus, the commands
+// and responses of every session are only logged when debugging. Sessions
+// are logged under the same id the driver uses for them
+type ftpLogger struct{}
+
+var _ server.Logger = ftpLogger{}
+
+func (ftpLogger) entry(sessionID string) *logrus.Entry {
+	entry := logrus.WithField("component", "ftp")
+	if sessionID != "" {
+		entry = entry.WithField("session", sessionID)
+	}
+	return entry
+}
+
+func (l ftpLogger) Print(sessionID string, message interface{}) {
+	l.entry(sessionID).Info(message)
+}
+
+func (l ftpLogger) Printf(sessionID string, format string, v ...interface{}) {
+	l.entry(sessionID).Infof(format, v...)
+}
+
+func (l ftpLogger) PrintCommand(sessionID string, command string, params string) {
+	if command == "PASS" {
+		params = "****"
+	}
+	l.entry(sessionID).WithFields(logrus.Fields{"command": command, "params": params}).Debug("command")
+}
+
+func (l ftpLogger) PrintResponse(sessionID string, code int, message string) {
+	l.entry(sessionID).WithFields(logrus.Fields{"code": code, "message": message}).Debug("response")
+}
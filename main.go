@@ -2,12 +2,12 @@ package main
 
 import (
 	"flag"
-	"fmt"
-	"log"
 	"os"
 
 	"github.com/BurntSushi/toml"
 	"github.com/freman/scantp/driver"
+	"github.com/freman/scantp/metrics"
+	"github.com/sirupsen/logrus"
 	"goftp.io/server"
 )
 
@@ -16,18 +16,28 @@ func main() {
 	flag.Parse()
 
 	if *configFile == "" {
-		log.Println("Configuration file is required")
+		logrus.Error("Configuration file is required")
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	md, err := toml.DecodeFile(*configFile, &config)
 	if err != nil {
-		log.Println("Unable to parse configuration file")
-		log.Fatal(err)
+		logrus.WithError(err).Fatal("Unable to parse configuration file")
+	}
+
+	if err := config.configureLogging(); err != nil {
+		logrus.WithError(err).Fatal("Invalid configuration")
+	}
+
+	if err := config.validate(md); err != nil {
+		logrus.WithError(err).Fatal("Invalid configuration")
 	}
 
 	mdf := &driver.MultipleDriverFactory{}
+	if len(config.Users) > 0 {
+		mdf.Authorizer = config
+	}
 
 	for pathName, prim := range config.Paths {
 		var tmp struct {
@@ -35,26 +45,34 @@ func main() {
 		}
 
 		if err := md.PrimitiveDecode(prim, &tmp); err != nil {
-			log.Printf("Unable to parse configuration file for %s", pathName)
-			log.Fatal(err)
+			logrus.WithError(err).WithField("path", pathName).Fatal("Unable to parse configuration file")
 		}
 
-		fmt.Println("Configuring", tmp.Type, pathName)
+		logrus.WithFields(logrus.Fields{"path": pathName, "driver": tmp.Type}).Info("Configuring")
 
 		if err := mdf.AddPath(pathName, tmp.Type, md, prim); err != nil {
-			log.Printf("Unable to start %s for %s", tmp.Type, pathName)
-			log.Fatal(err)
+			logrus.WithError(err).WithFields(logrus.Fields{"path": pathName, "driver": tmp.Type}).Fatal("Unable to start")
 		}
 	}
 
-	ftpServer := server.NewServer(&server.ServerOpts{
-		Factory:  mdf,
-		Hostname: config.Host,
-		Port:     config.Port,
-		Auth:     config,
-	})
+	listeners, err := config.listeners(mdf)
+	if err != nil {
+		logrus.WithError(err).Fatal("Unable to configure listeners")
+	}
 
-	if err := ftpServer.ListenAndServe(); err != nil {
-		log.Fatal(err)
+	errs := make(chan error, len(listeners)+1)
+	if config.Metrics != "" {
+		go func() {
+			errs <- metrics.ListenAndServe(config.Metrics)
+		}()
 	}
+
+	for _, opts := range listeners {
+		ftpServer := server.NewServer(opts)
+		go func() {
+			errs <- ftpServer.ListenAndServe()
+		}()
+	}
+
+	logrus.Fatal(<-errs)
 }
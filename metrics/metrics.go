@@ -0,0 +1,148 @@
+// Package metrics holds the Prometheus metrics scantp exports, they're
+// always collected but only served when a metrics listener is configured
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "scantp"
+
+var (
+	logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by user and result.",
+	}, []string{"user", "result"})
+
+	uploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "Files delivered to a path's driver by result.",
+	}, []string{"path", "driver", "result"})
+
+	uploadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes delivered to a path's driver.",
+	}, []string{"path", "driver"})
+
+	uploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "How long delivering a file to a path's driver took.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"path", "driver", "result"})
+
+	failures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "failures_total",
+		Help:      "Uploads that were lost or refused by path and reason.",
+	}, []string{"path", "reason"})
+
+	seafileRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "seafile_requests_total",
+		Help:      "Requests made to the Seafile API by method and status code.",
+	}, []string{"method", "code"})
+)
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// Login counts a login attempt, user should be empty for unknown users so
+// anyone guessing names can't blow out the number of series
+func Login(user string, err error) {
+	if user == "" {
+		user = "unknown"
+	}
+	logins.WithLabelValues(user, result(err)).Inc()
+}
+
+// Upload records a file being delivered to the driver of a path
+func Upload(path, driver string, started time.Time, n int64, err error) {
+	uploads.WithLabelValues(path, driver, result(err)).Inc()
+	uploadBytes.WithLabelValues(path, driver).Add(float64(n))
+	uploadDuration.WithLabelValues(path, driver, result(err)).Observe(time.Since(started).Seconds())
+}
+
+// Failure counts an upload that didn't make it for reason, one of conflict,
+// processor, gave_up or merge
+func Failure(path, reason string) {
+	failures.WithLabelValues(path, reason).Inc()
+}
+
+// SpoolDepth reports how many files are waiting in a path's spool, a path
+// that's set up again replaces the last one
+func SpoolDepth(path string, depth func() int) {
+	spoolDepths.mu.Lock()
+	defer spoolDepths.mu.Unlock()
+	spoolDepths.depths[path] = depth
+}
+
+var spoolDepths = func() *spoolCollector {
+	c := &spoolCollector{
+		desc:   prometheus.NewDesc(namespace+"_spool_depth", "Files waiting in a path's spool to be delivered.", []string{"path"}, nil),
+		depths: make(map[string]func() int),
+	}
+	prometheus.MustRegister(c)
+	return c
+}()
+
+// spoolCollector asks each spool how deep it is when scraped
+type spoolCollector struct {
+	desc *prometheus.Desc
+
+	mu     sync.Mutex
+	depths map[string]func() int
+}
+
+func (c *spoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *spoolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, depth := range c.depths {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(depth()), path)
+	}
+}
+
+// SeafileTransport counts the requests made through next, failed requests
+// that never got a response are counted with a code of 0
+func SeafileTransport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		code := 0
+		if err == nil {
+			code = resp.StatusCode
+		}
+		seafileRequests.WithLabelValues(r.Method, strconv.Itoa(code)).Inc()
+		return resp, err
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
+
+// ListenAndServe serves the metrics on /metrics
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
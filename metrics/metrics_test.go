@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSpoolDepthReplaced(t *testing.T) {
+	SpoolDepth("docs", func() int { return 1 })
+	SpoolDepth("docs", func() int { return 2 })
+
+	if got := testutil.ToFloat64(spoolDepths); got != 2 {
+		t.Errorf("spool depth is %v, expected 2", got)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	Failure("docs", "conflict")
+	Failure("docs", "conflict")
+	Failure("docs", "gave_up")
+
+	if got := testutil.ToFloat64(failures.WithLabelValues("docs", "conflict")); got != 2 {
+		t.Errorf("counted %v conflicts, expected 2", got)
+	}
+	if got := testutil.ToFloat64(failures.WithLabelValues("docs", "gave_up")); got != 1 {
+		t.Errorf("counted %v give ups, expected 1", got)
+	}
+}